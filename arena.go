@@ -0,0 +1,156 @@
+package ffi
+
+// #include <stdlib.h>
+import "C"
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// argSlotSize is the size of the storage reserved for each argument value,
+	// which is large enough for long double and double _Complex.
+	argSlotSize = 16
+	// retSlotSize is the size of the storage reserved for return values, which
+	// libffi may widen to two registers.
+	retSlotSize = 16
+	// arenaAlign is the alignment of the arena allocations, argument and
+	// return slots may hold SSE or x87 values which libffi expects aligned
+	// like long double. The arena memory comes from malloc, which aligns it at
+	// least as much.
+	arenaAlign = 16
+)
+
+// arena is a block of C memory holding the argument values, string copies and
+// argument pointers of a call made by Call, so they are allocated and released
+// all at once. Arenas are reused across calls through arenaPool.
+//
+// Go pointers stored in the arena are pinned with pinner, which the caller
+// unpins once the call has returned.
+type arena struct {
+	mem    unsafe.Pointer
+	cap    uintptr
+	off    uintptr
+	pinner *runtime.Pinner
+}
+
+var arenaPool sync.Pool
+
+func acquireArena(size uintptr, pinner *runtime.Pinner) *arena {
+	a, _ := arenaPool.Get().(*arena)
+
+	if a == nil {
+		a = new(arena)
+		runtime.SetFinalizer(a, freeArena)
+	}
+
+	if a.cap < size {
+		C.free(a.mem)
+		a.mem, a.cap = Malloc(size), size
+	}
+
+	a.off = 0
+	a.pinner = pinner
+	return a
+}
+
+func releaseArena(a *arena) {
+	a.pinner = nil
+	arenaPool.Put(a)
+}
+
+func freeArena(a *arena) {
+	C.free(a.mem)
+}
+
+// alloc returns size bytes of zeroed memory aligned on arenaAlign bytes.
+func (a *arena) alloc(size uintptr) unsafe.Pointer {
+	size = alignArena(size)
+
+	if a.off+size > a.cap {
+		panic("ffi: argument arena overflow")
+	}
+
+	p := unsafe.Add(a.mem, a.off)
+	a.off += size
+	clear(unsafe.Slice((*byte)(p), size))
+	return p
+}
+
+// pin pins the Go memory that p points to, if any, so it can be stored in the
+// arena for the duration of the call.
+func (a *arena) pin(p unsafe.Pointer) unsafe.Pointer {
+	if p != nil {
+		a.pinner.Pin(p)
+	}
+	return p
+}
+
+// cstring copies s into the arena as a NUL-terminated C string.
+func (a *arena) cstring(s string) unsafe.Pointer {
+	return a.encode(s, UTF8)
+}
+
+// encode copies s into the arena as a NUL-terminated string in the encoding.
+func (a *arena) encode(s string, enc Encoding) unsafe.Pointer {
+	p := a.alloc(enc.maxSize(s))
+	enc.encode(p, s)
+	return p
+}
+
+// arenaSize returns the size of the arena needed to call a function with the
+// given return value and arguments, it must account for all the allocations
+// that Call makes.
+func arenaSize(ret reflect.Value, args []reflect.Value) uintptr {
+	size := alignArena(marshaledSize(makeRetType(ret), retSlotSize))
+	size += alignArena(uintptr(len(args)) * unsafe.Sizeof(unsafe.Pointer(nil)))
+
+	for _, a := range args {
+		if m, ok := marshalerOf(a); ok {
+			size += alignArena(marshaledSize(m.FFIType(), argSlotSize))
+			continue
+		}
+
+		size += argSlotSize
+
+		if a.Kind() == reflect.Struct && a.Type() == encodedType {
+			size += encodedSize(valueOfEncoded(a))
+			continue
+		}
+
+		if a.Kind() == reflect.Struct && a.Type() == outType {
+			size += argSlotSize
+
+			if out := a.Interface().(outValue); out.in {
+				a = out.ptr.Elem()
+			}
+		}
+
+		switch a.Kind() {
+		case reflect.String:
+			size += alignArena(uintptr(a.Len()) + 1)
+
+		case reflect.Ptr:
+			if !a.IsNil() && a.Type().Elem().Kind() == reflect.String {
+				size += alignArena(uintptr(a.Elem().Len()) + 1)
+			}
+
+		case reflect.Slice:
+			if a.Type().Elem().Kind() == reflect.String {
+				size += alignArena(uintptr(a.Len()+1) * unsafe.Sizeof(unsafe.Pointer(nil)))
+
+				for i, n := 0, a.Len(); i != n; i++ {
+					size += alignArena(uintptr(a.Index(i).Len()) + 1)
+				}
+			}
+		}
+	}
+
+	return size
+}
+
+func alignArena(size uintptr) uintptr {
+	return (size + arenaAlign - 1) &^ (arenaAlign - 1)
+}
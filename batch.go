@@ -0,0 +1,113 @@
+package ffi
+
+import "unsafe"
+
+// Batch queues calls to C functions and runs them all with a single cgo
+// transition, which makes sequences of calls to small functions much cheaper
+// than going through Interface.Call for each of them.
+//
+// Argument values are copied when a call is added to the batch, return values
+// are written to the ret pointers when the batch runs, with the size of the C
+// return type.
+//
+// Pointers passed as argument values are copied into slots which are not
+// visible to the garbage collector, they must reference C memory or pinned Go
+// memory until the batch has run.
+type Batch struct {
+	batchState
+
+	cifs    []Interface
+	rets    []unsafe.Pointer
+	calls   []batchCall
+	offsets []uintptr
+	slots   []uint64
+}
+
+// batchCall has the same layout as the C structure that the libffi backend
+// uses to describe batched calls.
+type batchCall struct {
+	cif  unsafe.Pointer
+	fptr unsafe.Pointer
+	args uintptr
+	ret  uintptr
+}
+
+func (b *Batch) Add(cif Interface, fptr unsafe.Pointer, ret unsafe.Pointer, args ...unsafe.Pointer) {
+	if len(args) != len(cif.args) {
+		panic("ffi: wrong number of arguments in batched call")
+	}
+
+	call := batchCall{
+		fptr: fptr,
+		args: uintptr(len(b.offsets)),
+	}
+
+	for i, a := range args {
+		b.offsets = append(b.offsets, uintptr(len(b.slots)))
+		b.slots = appendSlots(b.slots, a, cif.args[i].size())
+	}
+
+	call.ret = uintptr(len(b.slots))
+	b.slots = appendSlots(b.slots, nil, cif.ret.retSize())
+
+	b.cifs = append(b.cifs, cif)
+	b.rets = append(b.rets, ret)
+	b.calls = append(b.calls, call)
+}
+
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+func (b *Batch) Run() {
+	if len(b.calls) == 0 {
+		return
+	}
+
+	b.run()
+
+	for i, ret := range b.rets {
+		if ret == nil || b.cifs[i].ret.abi == Void.abi {
+			continue
+		}
+
+		// The return slot holds integers widened to the size of a register,
+		// only the bytes of the C type are copied so ret is not overrun.
+		size := b.cifs[i].ret.size()
+		slot := unsafe.Pointer(&b.slots[b.calls[i].ret])
+		copy(unsafe.Slice((*byte)(ret), size), unsafe.Slice((*byte)(slot), size))
+	}
+}
+
+func (b *Batch) Reset() {
+	for i := range b.cifs {
+		b.cifs[i] = Interface{}
+		b.rets[i] = nil
+		b.calls[i] = batchCall{}
+	}
+
+	b.cifs = b.cifs[:0]
+	b.rets = b.rets[:0]
+	b.calls = b.calls[:0]
+	b.offsets = b.offsets[:0]
+	b.slots = b.slots[:0]
+}
+
+func appendSlots(slots []uint64, value unsafe.Pointer, size uintptr) []uint64 {
+	n := len(slots)
+	m := int((size + 7) / 8)
+
+	if m == 0 {
+		m = 1
+	}
+
+	for i := 0; i != m; i++ {
+		slots = append(slots, 0)
+	}
+
+	if value != nil {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&slots[n])), size), unsafe.Slice((*byte)(value), size))
+	}
+
+	return slots
+}
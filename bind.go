@@ -0,0 +1,359 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// argLength records that the argument at index length holds the number of
+// elements of the slice passed as the argument at index slice.
+type argLength struct {
+	length int
+	slice  int
+}
+
+// WithLength returns a copy of cif where the argument at index length holds the
+// number of elements of the buffer passed as the argument at index slice.
+//
+// Functions bound with Bind take only the slice, its length is passed to C
+// automatically, and closures created with Interface.Closure receive a Go slice
+// of the length given by C.
+func (cif Interface) WithLength(length int, slice int) Interface {
+	if length < 0 || length >= len(cif.args) || slice < 0 || slice >= len(cif.args) || length == slice {
+		panic(fmt.Sprintf("ffi: invalid length annotation for arguments %d and %d of %s", length, slice, cif))
+	}
+
+	if !isIntegerType(cif.args[length]) {
+		panic(fmt.Sprintf("ffi: argument %d of %s cannot hold a length", length, cif))
+	}
+
+	if !sameType(cif.args[slice], Pointer) {
+		panic(fmt.Sprintf("ffi: argument %d of %s is not a pointer", slice, cif))
+	}
+
+	for _, l := range cif.lengths {
+		if l.length == length || l.slice == slice || l.length == slice || l.slice == length {
+			panic(fmt.Sprintf("ffi: arguments %d and %d of %s already have a length annotation", length, slice, cif))
+		}
+	}
+
+	cif.lengths = append(cif.lengths[:len(cif.lengths):len(cif.lengths)], argLength{length, slice})
+	return cif
+}
+
+// Bind returns a Go function of type F calling the C function at fptr with the
+// interface cif. The parameters of F map to the arguments of cif which are not
+// declared as lengths with WithLength.
+//
+// F may have an error as last result, which reports the errors of strict mode.
+// The ownership of the memory returned by the function is declared with
+// WithReturn, for example to release the strings that it returns or to give
+// the returned *Handle values a destructor.
+func Bind[F any](cif Interface, fptr unsafe.Pointer) F {
+	ft := reflect.TypeOf((*F)(nil)).Elem()
+	cif.checkFunc(ft, true)
+
+	hasErr := ft.NumOut() != 0 && ft.Out(ft.NumOut()-1) == errorType
+	hasRet := ft.NumOut() > 1 || (ft.NumOut() == 1 && !hasErr)
+
+	if hasRet {
+		cif.own.checkRetType(reflect.PtrTo(ft.Out(0)))
+	}
+
+	fn := reflect.MakeFunc(ft, func(in []reflect.Value) []reflect.Value {
+		var vret reflect.Value
+		var verr = reflect.Zero(errorType)
+
+		if hasRet {
+			vret = reflect.New(ft.Out(0))
+		}
+
+		args := cif.makeBoundArgs(in)
+
+		var pinner runtime.Pinner
+		defer pinner.Unpin()
+
+		if err := cif.checkBoundArgs(args); err == nil {
+			callValues(cif, fptr, vret, cif.own, cif.enc, args, &pinner)
+		} else if hasErr {
+			verr = reflect.ValueOf(&err).Elem()
+		} else {
+			panic(err)
+		}
+
+		out := make([]reflect.Value, 0, 2)
+
+		if hasRet {
+			out = append(out, vret.Elem())
+		}
+
+		if hasErr {
+			out = append(out, verr)
+		}
+
+		return out
+	})
+
+	return fn.Interface().(F)
+}
+
+func (cif *Interface) checkBoundArgs(args []reflect.Value) error {
+	if Strict {
+		return checkStrictArgs(args, cif.args)
+	}
+	return nil
+}
+
+// Closure is like the Closure function but uses cif as the C signature of the
+// function instead of deriving it from the Go function type, which allows using
+// the length annotations of cif.
+func (cif Interface) Closure(v interface{}) Function {
+	fv := reflect.ValueOf(v)
+	ft := fv.Type()
+	cif.checkFunc(ft, false)
+
+	var fast dispatcher
+
+	if len(cif.lengths) == 0 && !cif.derefs(ft) {
+		fast = makeDispatcher(v)
+	}
+
+	return makeClosureOf(fv, cif, fast)
+}
+
+// checkFunc panics if ft is not a function type compatible with cif. Bound
+// functions may have an error as last result, closures may receive the values
+// that pointer arguments declared with PointerTo point to.
+func (cif *Interface) checkFunc(ft reflect.Type, bound bool) {
+	if ft.Kind() != reflect.Func || ft.IsVariadic() {
+		panic(fmt.Sprintf("ffi: expected a function with a fixed number of arguments but got %s", ft))
+	}
+
+	if n := len(cif.args) - len(cif.lengths); ft.NumIn() != n {
+		panic(fmt.Sprintf("ffi: function %s must have %d parameters to match %s", ft, n, cif))
+	}
+
+	numOut := ft.NumOut()
+
+	if bound && numOut != 0 && ft.Out(numOut-1) == errorType {
+		numOut--
+	}
+
+	switch numOut {
+	case 0:
+		if cif.ret.abi != Void.abi {
+			panic(fmt.Sprintf("ffi: function %s must return a value to match %s", ft, cif))
+		}
+	case 1:
+		if t := makeRetType(reflect.New(ft.Out(0))); !sameType(t, cif.ret) {
+			panic(fmt.Sprintf("ffi: return type of %s (%s) does not match %s", ft, t, cif))
+		}
+	default:
+		panic(fmt.Sprintf("ffi: function %s must return at most one value", ft))
+	}
+
+	for i, k := range cif.goArgIndexes() {
+		in := ft.In(i)
+
+		_, hasLength := cif.lengthOf(k)
+
+		if hasLength && in.Kind() != reflect.Slice {
+			panic(fmt.Sprintf("ffi: parameter %d of %s must be a slice to match %s", i, ft, cif))
+		}
+
+		if !bound && !hasLength && isSliceView(in) {
+			panic(fmt.Sprintf("ffi: parameter %d of %s is a slice, its length must be declared with Interface.WithLength", i, ft))
+		}
+
+		if t := makeArgType(reflect.Zero(in)); !sameType(t, cif.args[k]) && (bound || !derefs(cif.args[k], in)) {
+			panic(fmt.Sprintf("ffi: type of parameter %d of %s (%s) does not match %s", i, ft, t, cif))
+		}
+	}
+}
+
+// goArgIndexes returns the indexes of the arguments of cif which are mapped to
+// parameters of Go functions, which are all the arguments but lengths.
+func (cif *Interface) goArgIndexes() []int {
+	indexes := make([]int, 0, len(cif.args))
+
+	for k := range cif.args {
+		if !cif.isLength(k) {
+			indexes = append(indexes, k)
+		}
+	}
+
+	return indexes
+}
+
+// derefs returns true if a closure of type ft receives the value that one of
+// the pointer arguments of cif points to.
+func (cif *Interface) derefs(ft reflect.Type) bool {
+	for i, k := range cif.goArgIndexes() {
+		if derefs(cif.args[k], ft.In(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cif *Interface) isLength(k int) bool {
+	for _, l := range cif.lengths {
+		if l.length == k {
+			return true
+		}
+	}
+	return false
+}
+
+func (cif *Interface) lengthOf(k int) (int, bool) {
+	for _, l := range cif.lengths {
+		if l.slice == k {
+			return l.length, true
+		}
+	}
+	return 0, false
+}
+
+// makeBoundArgs returns the values passed to C for the parameters of a bound
+// function, with the lengths of slices inserted where cif declares them.
+func (cif *Interface) makeBoundArgs(in []reflect.Value) []reflect.Value {
+	args := make([]reflect.Value, len(cif.args))
+
+	for i, k := range cif.goArgIndexes() {
+		args[k] = in[i]
+	}
+
+	for _, l := range cif.lengths {
+		args[l.length] = reflect.ValueOf(uint64(args[l.slice].Len()))
+	}
+
+	if cif.enc != UTF8 {
+		for k, a := range args {
+			if isStringType(a.Type()) {
+				args[k] = reflect.ValueOf(encodedValue{a, cif.enc})
+			}
+		}
+	}
+
+	return args
+}
+
+// makeGoArgs converts the arguments received by a closure to the parameters of
+// a Go function of type ft.
+func (cif *Interface) makeGoArgs(args *unsafe.Pointer, ft reflect.Type) []reflect.Value {
+	av := unsafe.Slice(args, len(cif.args))
+	in := make([]reflect.Value, ft.NumIn())
+
+	for i, k := range cif.goArgIndexes() {
+		if l, ok := cif.lengthOf(k); ok {
+			p, n := *(*unsafe.Pointer)(av[k]), loadLength(av[l], cif.args[l])
+
+			if ft.In(i).Elem().Kind() == reflect.String {
+				in[i] = makeGoStringsN(p, n, ft.In(i), cif.enc)
+			} else {
+				in[i] = makeGoSlice(p, n, ft.In(i))
+			}
+		} else if derefs(cif.args[k], ft.In(i)) {
+			in[i] = makeGoDeref(*(*unsafe.Pointer)(av[k]), ft.In(i))
+		} else if cif.enc != UTF8 && isStringType(ft.In(i)) {
+			in[i] = makeGoEncoded(av[k], ft.In(i), cif.enc)
+		} else {
+			in[i] = makeGoArg(av[k], ft.In(i))
+		}
+	}
+
+	return in
+}
+
+// makeGoDeref converts the C value at p to a Go value of type t, a NULL
+// pointer is converted to the zero value.
+func makeGoDeref(p unsafe.Pointer, t reflect.Type) reflect.Value {
+	if p == nil {
+		return reflect.Zero(t)
+	}
+	return makeGoArg(p, t)
+}
+
+// sliceHeader has the memory layout of Go slices.
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+// makeGoSlice returns a slice of type t viewing the n elements at p. The slice
+// header is built directly so that no array type is created for each length,
+// reflect never releases the types that it creates.
+func makeGoSlice(p unsafe.Pointer, n int, t reflect.Type) reflect.Value {
+	if p == nil {
+		return reflect.Zero(t)
+	}
+
+	if size := t.Elem().Size(); size != 0 && uintptr(n) > ^uintptr(0)/2/size {
+		panic(fmt.Sprintf("ffi: length %d of %s is too large", n, t))
+	}
+
+	return reflect.NewAt(t, unsafe.Pointer(&sliceHeader{p, n, n})).Elem()
+}
+
+// makeGoStringsN converts the array of n C strings at p to a Go slice of type
+// t, NULL entries are converted to empty strings.
+func makeGoStringsN(p unsafe.Pointer, n int, t reflect.Type, enc Encoding) reflect.Value {
+	if p == nil {
+		return reflect.Zero(t)
+	}
+
+	v := reflect.MakeSlice(t, n, n)
+
+	for i, a := range unsafe.Slice((*unsafe.Pointer)(p), n) {
+		v.Index(i).SetString(GoString(a, enc))
+	}
+
+	return v
+}
+
+// isSliceView returns true if closures receive parameters of type t as slices
+// viewing C memory, which requires knowing their length.
+func isSliceView(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.String
+}
+
+func isIntegerType(t Type) bool {
+	for _, it := range []Type{Int8, Int16, Int32, Int64, UInt8, UInt16, UInt32, UInt64} {
+		if sameType(t, it) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadLength(p unsafe.Pointer, t Type) int {
+	var n int64
+
+	switch {
+	case sameType(t, Int8):
+		n = int64(*(*int8)(p))
+	case sameType(t, Int16):
+		n = int64(*(*int16)(p))
+	case sameType(t, Int32):
+		n = int64(*(*int32)(p))
+	case sameType(t, Int64):
+		n = *(*int64)(p)
+	case sameType(t, UInt8):
+		n = int64(*(*uint8)(p))
+	case sameType(t, UInt16):
+		n = int64(*(*uint16)(p))
+	case sameType(t, UInt32):
+		n = int64(*(*uint32)(p))
+	default:
+		n = int64(*(*uint64)(p))
+	}
+
+	if n < 0 {
+		return 0
+	}
+
+	return int(n)
+}
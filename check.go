@@ -0,0 +1,143 @@
+package ffi
+
+// static void ffi_check_pointer__(void *p) {}
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Checked enables the validation of arguments passed to Call. When set, the
+// Go memory that pointer and slice arguments refer to is walked before the
+// call and Call returns a *PointerError instead of calling the function if it
+// holds Go pointers that are not pinned, which the cgo rules forbid.
+//
+// The check relies on the runtime to tell Go pointers from C pointers, it does
+// nothing when cgocheck is disabled with GODEBUG=cgocheck=0.
+var Checked bool
+
+// PointerError is returned by Call in checked mode when an argument refers to
+// Go memory that holds an unpinned Go pointer.
+type PointerError struct {
+	// Index of the argument in the list passed to Call.
+	Index int
+	// Type of the argument.
+	Type reflect.Type
+	// Path to the Go pointer from the argument, for example "arg[2].Name" for
+	// the Name field of the third element of a slice.
+	Path string
+}
+
+func (e *PointerError) Error() string {
+	return fmt.Sprintf("ffi: argument %d (%s) points to Go memory holding an unpinned Go pointer at %s, pin it with runtime.Pinner or allocate the memory in C", e.Index, e.Type, e.Path)
+}
+
+func checkArgValues(args []reflect.Value) error {
+	for i, a := range args {
+		if path, ok := checkArgValue(a); !ok {
+			return &PointerError{Index: i, Type: a.Type(), Path: path}
+		}
+	}
+	return nil
+}
+
+func checkArgValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		// pointers to strings are copied to C memory by Call, and values
+		// owning C memory are passed as the pointer to that memory
+		if _, ok := cMemoryOf(v); ok {
+			break
+		}
+
+		if e := v.Type().Elem(); !v.IsNil() && e.Kind() != reflect.String && hasPointers(e) {
+			return checkMemory(v.Elem(), "(*arg)")
+		}
+
+	case reflect.Slice:
+		// slices of strings are copied to C memory by Call
+		if e := v.Type().Elem(); e.Kind() != reflect.String && hasPointers(e) {
+			for i, n := 0, v.Len(); i != n; i++ {
+				if path, ok := checkMemory(v.Index(i), fmt.Sprintf("arg[%d]", i)); !ok {
+					return path, false
+				}
+			}
+		}
+	}
+	return "", true
+}
+
+// checkMemory walks the memory that holds v, which must be addressable, but
+// does not follow the pointers it finds since the cgo rules only apply to the
+// memory directly referenced by arguments.
+func checkMemory(v reflect.Value, path string) (string, bool) {
+	addr := unsafe.Pointer(v.UnsafeAddr())
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func, reflect.String, reflect.Slice:
+		if isUnpinnedGoPointer(*(*unsafe.Pointer)(addr)) {
+			return path, false
+		}
+
+	case reflect.Interface:
+		if isUnpinnedGoPointer((*[2]unsafe.Pointer)(addr)[1]) {
+			return path, false
+		}
+
+	case reflect.Array:
+		if hasPointers(v.Type().Elem()) {
+			for i, n := 0, v.Len(); i != n; i++ {
+				if p, ok := checkMemory(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); !ok {
+					return p, false
+				}
+			}
+		}
+
+	case reflect.Struct:
+		for i, n := 0, v.NumField(); i != n; i++ {
+			if p, ok := checkMemory(v.Field(i), path+"."+v.Type().Field(i).Name); !ok {
+				return p, false
+			}
+		}
+	}
+
+	return "", true
+}
+
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func, reflect.String, reflect.Slice, reflect.Interface:
+		return true
+
+	case reflect.Array:
+		return t.Len() != 0 && hasPointers(t.Elem())
+
+	case reflect.Struct:
+		for i, n := 0, t.NumField(); i != n; i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type pointerHolder struct {
+	p unsafe.Pointer
+}
+
+// isUnpinnedGoPointer asks the cgo pointer check of the runtime whether p is
+// an unpinned Go pointer, by passing C a Go object holding it.
+func isUnpinnedGoPointer(p unsafe.Pointer) (yes bool) {
+	if p == nil {
+		return false
+	}
+
+	defer func() {
+		yes = recover() != nil
+	}()
+
+	C.ffi_check_pointer__(unsafe.Pointer(&pointerHolder{p}))
+	return
+}
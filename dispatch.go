@@ -0,0 +1,165 @@
+package ffi
+
+// #include <stdint.h>
+import "C"
+import (
+	"reflect"
+	"unsafe"
+)
+
+// dispatcher invokes a Go function with arguments and return value stored in
+// the slots handed to a closure by libffi.
+type dispatcher func(ret unsafe.Pointer, args *unsafe.Pointer)
+
+// ClosureOf is like Closure but takes the function with its static type. When
+// F is one of the common function types listed in makeDispatcher the closure
+// calls the function directly instead of going through reflection.
+func ClosureOf[F any](f F) Function {
+	return Closure(f)
+}
+
+// makeDispatcher returns a reflection-free dispatcher for functions of common
+// types, or nil if v has to be called through reflection.
+func makeDispatcher(v interface{}) dispatcher {
+	switch f := v.(type) {
+	case func():
+		return func(ret unsafe.Pointer, args *unsafe.Pointer) { f() }
+
+	case func(int):
+		return dispatch1v(f)
+	case func(int32):
+		return dispatch1v(f)
+	case func(unsafe.Pointer):
+		return dispatch1v(f)
+
+	case func(unsafe.Pointer, unsafe.Pointer):
+		return dispatch2v(f)
+
+	case func() int:
+		return dispatch0(f)
+	case func() int32:
+		return dispatch0(f)
+	case func() unsafe.Pointer:
+		return dispatch0(f)
+
+	case func(int) int:
+		return dispatch1(f)
+	case func(int32) int32:
+		return dispatch1(f)
+	case func(int64) int64:
+		return dispatch1(f)
+	case func(uint32) uint32:
+		return dispatch1(f)
+	case func(uint64) uint64:
+		return dispatch1(f)
+	case func(float32) float32:
+		return dispatch1(f)
+	case func(float64) float64:
+		return dispatch1(f)
+	case func(unsafe.Pointer) int:
+		return dispatch1(f)
+	case func(unsafe.Pointer) int32:
+		return dispatch1(f)
+	case func(unsafe.Pointer) unsafe.Pointer:
+		return dispatch1(f)
+
+	case func(int, int) int:
+		return dispatch2(f)
+	case func(int32, int32) int32:
+		return dispatch2(f)
+	case func(int64, int64) int64:
+		return dispatch2(f)
+	case func(float32, float32) float32:
+		return dispatch2(f)
+	case func(float64, float64) float64:
+		return dispatch2(f)
+	case func(unsafe.Pointer, unsafe.Pointer) int:
+		return dispatch2(f)
+	case func(unsafe.Pointer, unsafe.Pointer) int32:
+		return dispatch2(f)
+
+	case func(unsafe.Pointer, unsafe.Pointer, unsafe.Pointer) int:
+		return dispatch3(f)
+	case func(unsafe.Pointer, unsafe.Pointer, unsafe.Pointer) int32:
+		return dispatch3(f)
+	}
+
+	return nil
+}
+
+func dispatch0[R Scalar](f func() R) dispatcher {
+	rk := kindOf[R]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		storeRet(ret, rk, f())
+	}
+}
+
+func dispatch1[R, A1 Scalar](f func(A1) R) dispatcher {
+	rk, k1 := kindOf[R](), kindOf[A1]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		av := unsafe.Slice(args, 1)
+		storeRet(ret, rk, f(loadArg[A1](av[0], k1)))
+	}
+}
+
+func dispatch2[R, A1, A2 Scalar](f func(A1, A2) R) dispatcher {
+	rk, k1, k2 := kindOf[R](), kindOf[A1](), kindOf[A2]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		av := unsafe.Slice(args, 2)
+		storeRet(ret, rk, f(loadArg[A1](av[0], k1), loadArg[A2](av[1], k2)))
+	}
+}
+
+func dispatch3[R, A1, A2, A3 Scalar](f func(A1, A2, A3) R) dispatcher {
+	rk, k1, k2, k3 := kindOf[R](), kindOf[A1](), kindOf[A2](), kindOf[A3]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		av := unsafe.Slice(args, 3)
+		storeRet(ret, rk, f(loadArg[A1](av[0], k1), loadArg[A2](av[1], k2), loadArg[A3](av[2], k3)))
+	}
+}
+
+func dispatch1v[A1 Scalar](f func(A1)) dispatcher {
+	k1 := kindOf[A1]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		av := unsafe.Slice(args, 1)
+		f(loadArg[A1](av[0], k1))
+	}
+}
+
+func dispatch2v[A1, A2 Scalar](f func(A1, A2)) dispatcher {
+	k1, k2 := kindOf[A1](), kindOf[A2]()
+	return func(ret unsafe.Pointer, args *unsafe.Pointer) {
+		av := unsafe.Slice(args, 2)
+		f(loadArg[A1](av[0], k1), loadArg[A2](av[1], k2))
+	}
+}
+
+func kindOf[T Scalar]() reflect.Kind {
+	return reflect.TypeOf((*T)(nil)).Elem().Kind()
+}
+
+// Go int and uint are passed as C int and unsigned int (see makeArgType), all
+// other scalar types have the same size in Go and C.
+
+func loadArg[T Scalar](p unsafe.Pointer, k reflect.Kind) (v T) {
+	switch k {
+	case reflect.Int:
+		*(*int)(unsafe.Pointer(&v)) = int(*(*C.int)(p))
+	case reflect.Uint:
+		*(*uint)(unsafe.Pointer(&v)) = uint(*(*C.uint)(p))
+	default:
+		v = *(*T)(p)
+	}
+	return
+}
+
+func storeRet[T Scalar](p unsafe.Pointer, k reflect.Kind, v T) {
+	switch k {
+	case reflect.Int:
+		*(*C.int)(p) = C.int(*(*int)(unsafe.Pointer(&v)))
+	case reflect.Uint:
+		*(*C.uint)(p) = C.uint(*(*uint)(unsafe.Pointer(&v)))
+	default:
+		*(*T)(p) = v
+	}
+}
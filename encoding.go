@@ -0,0 +1,321 @@
+package ffi
+
+// #include <stdlib.h>
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Encoding is the representation of strings exchanged with C.
+type Encoding int
+
+const (
+	// UTF8 strings are arrays of char, this is the default encoding.
+	UTF8 Encoding = iota
+	// WChar strings are arrays of wchar_t holding UTF-32 code points.
+	WChar
+	// UTF16 strings are arrays of char16_t, as used by ICU or Java.
+	UTF16
+	// Latin1 strings are arrays of char holding ISO-8859-1 characters,
+	// characters which cannot be represented are replaced by '?'.
+	Latin1
+)
+
+func (enc Encoding) String() string {
+	switch enc {
+	case UTF8:
+		return "UTF-8"
+	case WChar:
+		return "wchar_t"
+	case UTF16:
+		return "UTF-16"
+	case Latin1:
+		return "Latin-1"
+	default:
+		return "unknown"
+	}
+}
+
+// unitSize returns the size of the code units of strings in the encoding.
+func (enc Encoding) unitSize() uintptr {
+	switch enc {
+	case WChar:
+		return unsafe.Sizeof(C.wchar_t(0))
+	case UTF16:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// maxSize returns the maximum size of s in the encoding, including the NUL
+// terminator.
+func (enc Encoding) maxSize(s string) uintptr {
+	switch enc {
+	case UTF8:
+		return uintptr(len(s)) + 1
+	case UTF16:
+		return 2 * (2*uintptr(utf8.RuneCountInString(s)) + 1)
+	default:
+		return enc.unitSize() * (uintptr(utf8.RuneCountInString(s)) + 1)
+	}
+}
+
+// encode writes s in the encoding to the memory at p, which must be at least
+// maxSize(s) bytes long, and NUL-terminates it.
+func (enc Encoding) encode(p unsafe.Pointer, s string) {
+	switch enc {
+	case UTF8:
+		b := unsafe.Slice((*byte)(p), len(s)+1)
+		b[copy(b, s)] = 0
+
+	case WChar:
+		i := 0
+		for _, r := range s {
+			*(*C.wchar_t)(unsafe.Add(p, uintptr(i)*enc.unitSize())) = C.wchar_t(r)
+			i++
+		}
+		*(*C.wchar_t)(unsafe.Add(p, uintptr(i)*enc.unitSize())) = 0
+
+	case UTF16:
+		u := utf16.Encode([]rune(s))
+		b := unsafe.Slice((*uint16)(p), len(u)+1)
+		b[copy(b, u)] = 0
+
+	case Latin1:
+		i := 0
+		b := unsafe.Slice((*byte)(p), utf8.RuneCountInString(s)+1)
+		for _, r := range s {
+			if r > 0xFF {
+				r = '?'
+			}
+			b[i] = byte(r)
+			i++
+		}
+		b[i] = 0
+
+	default:
+		panic(fmt.Sprintf("ffi: invalid string encoding: %d", int(enc)))
+	}
+}
+
+// GoString converts the NUL-terminated C string at p in the given encoding to
+// a Go string, a NULL pointer is converted to an empty string.
+func GoString(p unsafe.Pointer, enc Encoding) string {
+	if p == nil {
+		return ""
+	}
+
+	n := 0
+	for size := enc.unitSize(); !isNulUnit(unsafe.Add(p, uintptr(n)*size), size); n++ {
+	}
+
+	return decodeString(p, n, enc)
+}
+
+// GoStringN converts the string stored in the size bytes at p in the given
+// encoding to a Go string, stopping at the first NUL character. It is meant
+// for fixed-size character arrays like the char name[N] fields of C structs,
+// which are not NUL-terminated when the string fills the array.
+func GoStringN(p unsafe.Pointer, size int, enc Encoding) string {
+	unit := enc.unitSize()
+	max := size / int(unit)
+	n := 0
+
+	for n < max && !isNulUnit(unsafe.Add(p, uintptr(n)*unit), unit) {
+		n++
+	}
+
+	return decodeString(p, n, enc)
+}
+
+func isNulUnit(p unsafe.Pointer, size uintptr) bool {
+	switch size {
+	case 1:
+		return *(*uint8)(p) == 0
+	case 2:
+		return *(*uint16)(p) == 0
+	default:
+		return *(*uint32)(p) == 0
+	}
+}
+
+// decodeString converts the n code units at p to a Go string.
+func decodeString(p unsafe.Pointer, n int, enc Encoding) string {
+	if n == 0 {
+		return ""
+	}
+
+	switch enc {
+	case UTF8:
+		return string(unsafe.Slice((*byte)(p), n))
+
+	case WChar:
+		r := make([]rune, n)
+		for i := range r {
+			r[i] = rune(*(*C.wchar_t)(unsafe.Add(p, uintptr(i)*enc.unitSize())))
+		}
+		return string(r)
+
+	case UTF16:
+		return string(utf16.Decode(unsafe.Slice((*uint16)(p), n)))
+
+	case Latin1:
+		r := make([]rune, n)
+		for i, b := range unsafe.Slice((*byte)(p), n) {
+			r[i] = rune(b)
+		}
+		return string(r)
+
+	default:
+		panic(fmt.Sprintf("ffi: invalid string encoding: %d", int(enc)))
+	}
+}
+
+// Encoded wraps a string, *string or []string argument passed to Call so it
+// is converted to C strings in the given encoding. It can also wrap the *string
+// or **string return value pointer to decode the returned string.
+func Encoded(v interface{}, enc Encoding) interface{} {
+	return encodedValue{reflect.ValueOf(v), enc}
+}
+
+type encodedValue struct {
+	value reflect.Value
+	enc   Encoding
+}
+
+var encodedType = reflect.TypeOf(encodedValue{})
+
+// WithEncoding returns a copy of cif where strings passed to and returned by
+// functions bound with Bind, and closures created with Interface.Closure, use
+// the given encoding.
+func (cif Interface) WithEncoding(enc Encoding) Interface {
+	cif.enc = enc
+	return cif
+}
+
+// isStringType returns true if t is one of the Go types converted to C strings.
+func isStringType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Ptr, reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func valueOfEncoded(v reflect.Value) (reflect.Value, Encoding) {
+	if v.Kind() == reflect.Struct && v.Type() == encodedType {
+		e := v.Interface().(encodedValue)
+
+		if !e.value.IsValid() || !isStringType(e.value.Type()) {
+			panic(fmt.Sprintf("ffi: only strings can be encoded but got %s", describeValue(e.value)))
+		}
+
+		return e.value, e.enc
+	}
+	return v, UTF8
+}
+
+// makeEncodedValue writes the string, *string or []string value v in the
+// encoding to the argument slot p.
+func makeEncodedValue(mem *arena, v reflect.Value, enc Encoding, p unsafe.Pointer) {
+	switch v.Kind() {
+	case reflect.String:
+		*(*unsafe.Pointer)(p) = mem.encode(v.String(), enc)
+
+	case reflect.Ptr:
+		if !v.IsNil() {
+			*(*unsafe.Pointer)(p) = mem.encode(v.Elem().String(), enc)
+		}
+
+	case reflect.Slice:
+		if !v.IsNil() {
+			*(*unsafe.Pointer)(p) = makeStringArray(mem, v, enc)
+		}
+	}
+}
+
+// encodedSize returns the arena space needed by makeEncodedValue.
+func encodedSize(v reflect.Value, enc Encoding) (size uintptr) {
+	switch v.Kind() {
+	case reflect.String:
+		size = alignArena(enc.maxSize(v.String()))
+
+	case reflect.Ptr:
+		if !v.IsNil() {
+			size = alignArena(enc.maxSize(v.Elem().String()))
+		}
+
+	case reflect.Slice:
+		if !v.IsNil() {
+			size = alignArena(uintptr(v.Len()+1) * unsafe.Sizeof(unsafe.Pointer(nil)))
+
+			for i, n := 0, v.Len(); i != n; i++ {
+				size += alignArena(enc.maxSize(v.Index(i).String()))
+			}
+		}
+	}
+	return
+}
+
+// makeGoEncoded converts the C string, string pointer or string array at p to
+// a Go value of type t.
+func makeGoEncoded(p unsafe.Pointer, t reflect.Type, enc Encoding) reflect.Value {
+	s := *(*unsafe.Pointer)(p)
+
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(GoString(s, enc)).Convert(t)
+
+	case reflect.Ptr:
+		if s == nil {
+			return reflect.Zero(t)
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().SetString(GoString(s, enc))
+		return v
+
+	default:
+		if s == nil {
+			return reflect.Zero(t)
+		}
+		v := reflect.MakeSlice(t, 0, 0)
+		for a := (*unsafe.Pointer)(s); *a != nil; a = nextUnsafePointer(a) {
+			v = reflect.Append(v, reflect.ValueOf(GoString(*a, enc)).Convert(t.Elem()))
+		}
+		return v
+	}
+}
+
+// setEncodedRetValue decodes the string returned in p to the *string or
+// **string v.
+func setEncodedRetValue(v reflect.Value, p unsafe.Pointer, enc Encoding) {
+	if !v.IsValid() || enc == UTF8 || !isStringType(v.Type().Elem()) {
+		return
+	}
+	v.Elem().Set(makeGoEncoded(p, v.Type().Elem(), enc))
+}
+
+// setEncodedRetPointer writes the string returned by a closure to the return
+// slot p as a C string allocated with malloc.
+func setEncodedRetPointer(p unsafe.Pointer, v reflect.Value, enc Encoding) {
+	switch {
+	case v.Kind() == reflect.String:
+	case v.Kind() == reflect.Ptr && !v.IsNil():
+		v = v.Elem()
+	default:
+		setRetPointer(p, v)
+		return
+	}
+
+	s := v.String()
+	m := Malloc(enc.maxSize(s))
+	enc.encode(m, s)
+	*(*unsafe.Pointer)(p) = m
+}
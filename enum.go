@@ -0,0 +1,132 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnumOf returns an enumeration type named name with the ABI of base, which
+// must be an integer type, and whose enumerators are the keys of values.
+//
+// Values of enumeration types are exchanged with C as Go integers, usually of
+// named types like `type Color int32`. They are formatted with the names of
+// their enumerators by FormatValue and Interface.FormatCall, and strict mode
+// reports values which are not part of the enumeration with a *RangeError
+// listing the enumerators.
+func EnumOf(base Type, name string, values map[string]int64) Type {
+	if _, _, ok := intRange(base); !ok {
+		panic(fmt.Sprintf("ffi: the base type of enum %s must be an integer type but got %s", name, base))
+	}
+
+	enum := &enumValues{
+		values: make(map[string]int64, len(values)),
+		names:  make(map[int64]string, len(values)),
+	}
+
+	keys := make([]string, 0, len(values))
+
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	// aliases of a value are formatted with the name sorting first
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	for _, k := range keys {
+		v := values[k]
+
+		if !inRange(reflect.ValueOf(v), base) {
+			panic(fmt.Sprintf("ffi: value of enumerator %s (%d) overflows %s", k, v, base))
+		}
+
+		enum.values[k] = v
+		enum.names[v] = k
+	}
+
+	return Type{abi: base.abi, name: name, enum: enum}
+}
+
+type enumValues struct {
+	values map[string]int64
+	names  map[int64]string
+}
+
+// formatNames returns the names of the enumerators ordered by value, aliases
+// are omitted.
+func (e *enumValues) formatNames() string {
+	values := make([]int64, 0, len(e.names))
+
+	for v := range e.names {
+		values = append(values, v)
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	names := make([]string, len(values))
+
+	for i, v := range values {
+		names[i] = e.names[v]
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// EnumValue returns the value of the enumerator with the given name, and false
+// if t has no such enumerator.
+func (t Type) EnumValue(name string) (int64, bool) {
+	if t.enum == nil {
+		return 0, false
+	}
+	v, ok := t.enum.values[name]
+	return v, ok
+}
+
+// FormatValue returns the string representation of the Go value v passed to
+// or received from C for the type t. Integers of enumeration types are
+// formatted with the name of their enumerator, strings passed for pointers are
+// quoted and Go pointers given for types created with PointerTo are formatted
+// as the value they point to, like &42.
+func (t Type) FormatValue(v interface{}) string {
+	if n, ok := enumInt(reflect.ValueOf(v)); ok && t.enum != nil {
+		if name, ok := t.enum.names[n]; ok {
+			return name
+		}
+		return strconv.FormatInt(n, 10)
+	}
+
+	if t.abi != nil && sameType(t, Pointer) {
+		return formatPointer(reflect.ValueOf(v), t.elem)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// isEnumValue returns true if v is one of the values of t, values which are
+// not integers or types which are not enumerations are ignored.
+func isEnumValue(v reflect.Value, t Type) bool {
+	if t.enum == nil {
+		return true
+	}
+
+	n, ok := enumInt(v)
+
+	if !ok {
+		return true
+	}
+
+	_, ok = t.enum.names[n]
+	return ok
+}
+
+func enumInt(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), true
+	default:
+		return 0, false
+	}
+}
@@ -1,11 +1,8 @@
 package ffi
 
-// #include <ffi.h>
 // #include <stdint.h>
 // #include <stdlib.h>
 //
-// typedef void (*function)(void);
-//
 // static int ffi_test_abs__(int n) {
 //   return n < 0 ? -n : n;
 // }
@@ -16,17 +13,13 @@ import (
 	"io"
 	"reflect"
 	"runtime"
+	"runtime/cgo"
+	"strings"
 	"unsafe"
 )
 
 type Status int
 
-const (
-	OK         Status = Status(C.FFI_OK)
-	BadTypedef Status = Status(C.FFI_BAD_TYPEDEF)
-	BadABI     Status = Status(C.FFI_BAD_ABI)
-)
-
 func (s Status) String() string {
 	switch s {
 	case OK:
@@ -45,37 +38,54 @@ func (s Status) Error() string {
 }
 
 type Type struct {
-	ffi_type *C.ffi_type
-	name     string
+	abi  *abiType
+	name string
+	elem *Type
+	enum *enumValues
+	agg  *aggregate
 }
 
 var (
-	Void Type = Type{&C.ffi_type_void, "void"}
+	Void Type = Type{abi: abiVoid, name: "void"}
+
+	UChar  Type = Type{abi: abiUChar, name: "unsigned char"}
+	UShort Type = Type{abi: abiUShort, name: "unsigned short"}
+	UInt   Type = Type{abi: abiUInt, name: "unsigned int"}
+	ULong  Type = Type{abi: abiULong, name: "unsigned long"}
+
+	UInt8  Type = Type{abi: abiUInt8, name: "uint8_t"}
+	UInt16 Type = Type{abi: abiUInt16, name: "uint16_t"}
+	UInt32 Type = Type{abi: abiUInt32, name: "uint32_t"}
+	UInt64 Type = Type{abi: abiUInt64, name: "uint64_t"}
+
+	Char  Type = Type{abi: abiChar, name: "char"}
+	Short Type = Type{abi: abiShort, name: "short"}
+	Int   Type = Type{abi: abiInt, name: "int"}
+	Long  Type = Type{abi: abiLong, name: "long"}
+
+	Int8  Type = Type{abi: abiInt8, name: "int8_t"}
+	Int16 Type = Type{abi: abiInt16, name: "int16_t"}
+	Int32 Type = Type{abi: abiInt32, name: "int32_t"}
+	Int64 Type = Type{abi: abiInt64, name: "int64_t"}
 
-	UChar  Type = Type{&C.ffi_type_uchar, "unsigned char"}
-	UShort Type = Type{&C.ffi_type_ushort, "unsigned short"}
-	UInt   Type = Type{&C.ffi_type_uint, "unsigned int"}
-	ULong  Type = Type{&C.ffi_type_ulong, "unsigned long"}
+	Float  Type = Type{abi: abiFloat, name: "float"}
+	Double Type = Type{abi: abiDouble, name: "double"}
 
-	UInt8  Type = Type{&C.ffi_type_uint8, "uint8_t"}
-	UInt16 Type = Type{&C.ffi_type_uint16, "uint16_t"}
-	UInt32 Type = Type{&C.ffi_type_uint32, "uint32_t"}
-	UInt64 Type = Type{&C.ffi_type_uint64, "uint64_t"}
+	Pointer Type = Type{abi: abiPointer, name: "void *"}
 
-	Char  Type = Type{&C.ffi_type_schar, "char"}
-	Short Type = Type{&C.ffi_type_sshort, "short"}
-	Int   Type = Type{&C.ffi_type_sint, "int"}
-	Long  Type = Type{&C.ffi_type_slong, "long"}
+	Bool Type = Type{abi: abiBool, name: "_Bool"}
 
-	Int8  Type = Type{&C.ffi_type_sint8, "int8_t"}
-	Int16 Type = Type{&C.ffi_type_sint16, "int16_t"}
-	Int32 Type = Type{&C.ffi_type_sint32, "int32_t"}
-	Int64 Type = Type{&C.ffi_type_sint64, "int64_t"}
+	SizeT   Type = Type{abi: abiSizeT, name: "size_t"}
+	SSizeT  Type = Type{abi: abiSSizeT, name: "ssize_t"}
+	PtrDiff Type = Type{abi: abiPtrDiff, name: "ptrdiff_t"}
+	IntPtr  Type = Type{abi: abiIntPtr, name: "intptr_t"}
 
-	Float  Type = Type{&C.ffi_type_float, "float"}
-	Double Type = Type{&C.ffi_type_double, "double"}
+	ComplexFloat  Type = Type{abi: abiComplexFloat, name: "float _Complex"}
+	ComplexDouble Type = Type{abi: abiComplexDouble, name: "double _Complex"}
 
-	Pointer Type = Type{&C.ffi_type_pointer, "void *"}
+	// LongDoubleType is the C long double type, values of this type are held
+	// by the LongDouble Go type. It is only supported by the libffi backend.
+	LongDoubleType Type = Type{abi: abiLongDouble, name: "long double"}
 )
 
 func (t Type) String() string {
@@ -87,46 +97,52 @@ func (t Type) String() string {
 }
 
 type Interface struct {
-	ffi_cif  C.ffi_cif
-	ffi_ret  *C.ffi_type
-	ffi_args **C.ffi_type
+	abiInterface
 
-	ret  Type
-	args []Type
+	ret     Type
+	args    []Type
+	lengths []argLength
+	enc     Encoding
+	own     Ownership
+	wide    bool
 }
 
 func Prepare(ret Type, args ...Type) (cif Interface) {
-	cif.ffi_ret = ret.ffi_type
+	cif = prepareInterface(ret, args)
+	cif.releaseOnGC()
+	return
+}
+
+// prepareInterface is like Prepare but returns an interface which must be
+// released explicitly, it is used by Call to avoid registering a finalizer for
+// each call.
+func prepareInterface(ret Type, args []Type) (cif Interface) {
 	cif.ret = ret
 	cif.args = args
-	argc := len(args)
-
-	if argc != 0 {
-		va := make([]*C.ffi_type, argc)
-
-		for i, a := range args {
-			va[i] = a.ffi_type
-		}
+	cif.wide = ret.size() > 8
 
-		cif.ffi_args = &va[0]
+	for _, a := range args {
+		cif.wide = cif.wide || a.size() > 8
 	}
 
-	if status := Status(C.ffi_prep_cif(&cif.ffi_cif, C.FFI_DEFAULT_ABI, C.uint(argc), cif.ffi_ret, cif.ffi_args)); status != OK {
+	if status := cif.prepare(); status != OK {
+		cif.release()
 		panic(status)
 	}
 
 	return
 }
 
-func (cif Interface) Call(fptr unsafe.Pointer, ret unsafe.Pointer, args ...unsafe.Pointer) (err error) {
-	var va *unsafe.Pointer
-
-	if len(args) != 0 {
-		va = &args[0]
+// checkBits panics if cif has values which do not fit in the 64 bits slots
+// used by the typed call functions and frames.
+func (cif *Interface) checkBits() {
+	if cif.wide {
+		panic(fmt.Sprintf("ffi: %s has values larger than 64 bits", cif))
 	}
+}
 
-	_, err = C.ffi_call(&cif.ffi_cif, C.function(fptr), ret, va)
-	return
+func (cif Interface) Call(fptr unsafe.Pointer, ret unsafe.Pointer, args ...unsafe.Pointer) (err error) {
+	return cif.call(fptr, ret, args)
 }
 
 func (self Interface) String() string {
@@ -147,31 +163,79 @@ func (self Interface) Format(f fmt.State, r rune) {
 	io.WriteString(f, ")")
 }
 
+// FormatCall returns a representation of a call through cif to the function
+// named name, like `strnlen("hello", 16) = 5`, which is meant to trace calls.
+// The arguments and the return value are Go values formatted with the
+// FormatValue method of their C type, ret is ignored when cif returns void.
+func (cif Interface) FormatCall(name string, ret interface{}, args ...interface{}) string {
+	if len(args) != len(cif.args) {
+		panic(fmt.Sprintf("ffi: %s takes %d arguments but %d were given", cif, len(cif.args), len(args)))
+	}
+
+	var s strings.Builder
+	s.WriteString(name)
+	s.WriteString("(")
+
+	for i, a := range args {
+		if i != 0 {
+			s.WriteString(", ")
+		}
+		s.WriteString(cif.args[i].FormatValue(a))
+	}
+
+	s.WriteString(")")
+
+	if cif.ret.abi != Void.abi {
+		s.WriteString(" = ")
+		s.WriteString(cif.ret.FormatValue(ret))
+	}
+
+	return s.String()
+}
+
 func Call(fptr unsafe.Pointer, ret interface{}, args ...interface{}) (err error) {
-	vret := valueOfRet(ret)
+	vret, own, enc := valueOfOwnedRet(ret)
 	varg := valueOfArgs(args)
 
-	rett := makeRetType(vret)
-	retv := makeRetValue(vret)
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	pinArgValues(varg, &pinner)
 
+	if Checked {
+		if err = checkArgValues(varg); err != nil {
+			return
+		}
+	}
+
+	rett := makeRetType(vret)
 	argt := makeArgTypes(varg)
-	argv := makeArgValues(varg)
 
-	defer freeArgValues(argv, varg)
+	if Strict {
+		if err = checkStrictArgs(varg, argt); err != nil {
+			return
+		}
+	}
 
-	Prepare(rett, argt...).Call(fptr, retv, argv...)
+	cif := prepareInterface(rett, argt)
+	defer cif.release()
 
-	setRetValue(vret, retv)
+	callValues(cif, fptr, vret, own, enc, varg, &pinner)
 	return
 }
 
-func freeArgValues(varg []unsafe.Pointer, args []reflect.Value) {
-	for i, a := range args {
-		switch a.Kind() {
-		case reflect.String:
-			C.free(*(*unsafe.Pointer)(varg[i]))
-		}
-	}
+func callValues(cif Interface, fptr unsafe.Pointer, vret reflect.Value, own Ownership, enc Encoding, varg []reflect.Value, pinner *runtime.Pinner) {
+	mem := acquireArena(arenaSize(vret, varg), pinner)
+	defer releaseArena(mem)
+
+	retv := makeRetValue(mem, vret)
+	argv := makeArgValues(mem, varg)
+
+	cif.Call(fptr, retv, argv...)
+
+	setRetValue(vret, retv)
+	setEncodedRetValue(vret, retv, enc)
+	own.setRetValue(vret, retv)
+	setOutValues(varg, argv)
 }
 
 func valueOfRet(ret interface{}) reflect.Value {
@@ -201,10 +265,19 @@ type Function interface {
 }
 
 type function struct {
+	*callback
+	fptr   unsafe.Pointer
+	mptr   unsafe.Pointer
+	handle cgo.Handle
+}
+
+// callback is the part of a closure that C code reaches through a cgo.Handle,
+// it must not reference the function so the finalizer of the latter can run.
+type callback struct {
 	Interface
-	fptr unsafe.Pointer
-	mptr unsafe.Pointer
-	call reflect.Value
+	call  reflect.Value
+	fast  dispatcher
+	deref bool
 }
 
 func (fn *function) Call(ret unsafe.Pointer, args ...unsafe.Pointer) error {
@@ -232,14 +305,10 @@ func Closure(v interface{}) Function {
 		panic(fmt.Sprintf("ffi: closures with a variable number of arguments are not supported"))
 	}
 
-	return makeClosure(fv, ft)
+	return makeClosure(fv, ft, makeDispatcher(v))
 }
 
-func makeClosure(fv reflect.Value, ft reflect.Type) *function {
-	fn := &function{
-		call: fv,
-	}
-
+func makeClosure(fv reflect.Value, ft reflect.Type, fast dispatcher) *function {
 	var rt = Void
 	var at []Type
 
@@ -251,39 +320,75 @@ func makeClosure(fv reflect.Value, ft reflect.Type) *function {
 		at = make([]Type, n)
 
 		for i := 0; i != n; i++ {
+			if isSliceView(ft.In(i)) {
+				panic(fmt.Sprintf("ffi: parameter %d of %s is a slice, its length must be declared with Interface.WithLength", i, ft))
+			}
 			at[i] = makeArgType(reflect.Zero(ft.In(i)))
 		}
 	}
 
-	fn.Interface = Prepare(rt, at...)
+	return makeClosureOf(fv, Prepare(rt, at...), fast)
+}
+
+func makeClosureOf(fv reflect.Value, cif Interface, fast dispatcher) *function {
+	cb := &callback{
+		Interface: cif,
+		call:      fv,
+		fast:      fast,
+		deref:     cif.derefs(fv.Type()),
+	}
+
+	fn := &function{
+		callback: cb,
+		handle:   cgo.NewHandle(cb),
+	}
 
 	if err := constructClosure(fn); err != nil {
+		fn.handle.Delete()
 		panic(err)
 	}
 
-	runtime.SetFinalizer(fn, destroyClosure)
+	runtime.SetFinalizer(fn, freeClosure)
 	return fn
 }
 
-//export GoClosureCallback
-func GoClosureCallback(cif *C.ffi_cif, ret unsafe.Pointer, args *unsafe.Pointer, data unsafe.Pointer) {
-	fn := (*function)(data)
-	fv := fn.call
+func freeClosure(fn *function) {
+	destroyClosure(fn)
+	fn.handle.Delete()
+}
+
+func (cb *callback) invoke(ret unsafe.Pointer, args *unsafe.Pointer) {
+	if cb.fast != nil {
+		cb.fast(ret, args)
+		return
+	}
+
+	fv := cb.call
 	ft := fv.Type()
 
-	ac := ft.NumIn()
-	av := make([]reflect.Value, ac)
+	var av []reflect.Value
 
-	for i := 0; i != ac; i++ {
-		av[i] = makeGoArg(*args, ft.In(i))
-		args = nextUnsafePointer(args)
+	if len(cb.lengths) != 0 || cb.enc != UTF8 || cb.deref {
+		av = cb.makeGoArgs(args, ft)
+	} else {
+		ac := ft.NumIn()
+		av = make([]reflect.Value, ac)
+
+		for i := 0; i != ac; i++ {
+			av[i] = makeGoArg(*args, ft.In(i))
+			args = nextUnsafePointer(args)
+		}
 	}
 
 	rv := fv.Call(av)
 	rc := len(rv)
 
 	if rc > 0 {
-		setRetPointer(ret, rv[0])
+		if cb.enc != UTF8 {
+			setEncodedRetPointer(ret, rv[0], cb.enc)
+		} else {
+			setRetPointer(ret, rv[0])
+		}
 	}
 
 	if rc > 1 {
@@ -292,62 +397,148 @@ func GoClosureCallback(cif *C.ffi_cif, ret unsafe.Pointer, args *unsafe.Pointer,
 }
 
 func makeGoArg(p unsafe.Pointer, t reflect.Type) reflect.Value {
+	if isUnmarshaler(t) {
+		return unmarshalGoValue(p, t)
+	}
+
 	switch t.Kind() {
 	case reflect.Int:
-		return reflect.ValueOf(int(*((*C.int)(p))))
+		return reflect.ValueOf(int(*((*C.int)(p)))).Convert(t)
 
 	case reflect.Int8:
-		return reflect.ValueOf(int8(*((*C.int8_t)(p))))
+		return reflect.ValueOf(int8(*((*C.int8_t)(p)))).Convert(t)
 
 	case reflect.Int16:
-		return reflect.ValueOf(int16(*((*C.int16_t)(p))))
+		return reflect.ValueOf(int16(*((*C.int16_t)(p)))).Convert(t)
 
 	case reflect.Int32:
-		return reflect.ValueOf(int32(*((*C.int32_t)(p))))
+		return reflect.ValueOf(int32(*((*C.int32_t)(p)))).Convert(t)
 
 	case reflect.Int64:
-		return reflect.ValueOf(int64(*((*C.int64_t)(p))))
+		return reflect.ValueOf(int64(*((*C.int64_t)(p)))).Convert(t)
 
 	case reflect.Uint:
-		return reflect.ValueOf(uint(*((*C.uint)(p))))
+		return reflect.ValueOf(uint(*((*C.uint)(p)))).Convert(t)
 
 	case reflect.Uint8:
-		return reflect.ValueOf(uint8(*((*C.uint8_t)(p))))
+		return reflect.ValueOf(uint8(*((*C.uint8_t)(p)))).Convert(t)
 
 	case reflect.Uint16:
-		return reflect.ValueOf(uint16(*((*C.uint16_t)(p))))
+		return reflect.ValueOf(uint16(*((*C.uint16_t)(p)))).Convert(t)
 
 	case reflect.Uint32:
-		return reflect.ValueOf(uint32(*((*C.uint32_t)(p))))
+		return reflect.ValueOf(uint32(*((*C.uint32_t)(p)))).Convert(t)
 
 	case reflect.Uint64:
-		return reflect.ValueOf(uint64(*((*C.uint64_t)(p))))
+		return reflect.ValueOf(uint64(*((*C.uint64_t)(p)))).Convert(t)
 
 	case reflect.Uintptr:
-		return reflect.ValueOf(uintptr(*((*C.size_t)(p))))
+		return reflect.ValueOf(uintptr(*((*C.size_t)(p)))).Convert(t)
 
 	case reflect.Float32:
-		return reflect.ValueOf(float32(*((*C.float)(p))))
+		return reflect.ValueOf(float32(*((*C.float)(p)))).Convert(t)
 
 	case reflect.Float64:
-		return reflect.ValueOf(float64(*((*C.double)(p))))
+		return reflect.ValueOf(float64(*((*C.double)(p)))).Convert(t)
+
+	case reflect.Bool:
+		return reflect.ValueOf(bool(*((*C._Bool)(p)))).Convert(t)
+
+	case reflect.Complex64:
+		return reflect.ValueOf(complex64(*((*C.complexfloat)(p)))).Convert(t)
+
+	case reflect.Complex128:
+		return reflect.ValueOf(complex128(*((*C.complexdouble)(p)))).Convert(t)
 
 	case reflect.String:
-		return reflect.ValueOf(C.GoString(*((**C.char)(p))))
+		return reflect.ValueOf(C.GoString(*((**C.char)(p)))).Convert(t)
 
 	case reflect.UnsafePointer:
-		return reflect.ValueOf(p)
+		// p is the address of the argument slot, the parameter receives the
+		// pointer passed by C like the fast dispatchers do
+		return reflect.ValueOf(*((*unsafe.Pointer)(p))).Convert(t)
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return makeGoStrings(*(***C.char)(p), t)
+		}
+		// The length of other slices is only known for arguments declared
+		// with WithLength, closures receiving them are rejected when created.
+		panic(fmt.Sprintf("ffi: cannot convert C pointer to %s without a length", t))
+
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.String {
+			return makeGoStringPointer(*(**C.char)(p), t)
+		}
+		if ptr := *(*unsafe.Pointer)(p); ptr != nil {
+			return reflect.NewAt(t.Elem(), ptr).Convert(t)
+		}
+		return reflect.Zero(t)
 
 	default:
 		return reflect.ValueOf(nil)
 	}
 }
 
+// makeStringArray copies the strings of v to a NULL-terminated array of C
+// strings in the encoding allocated in the arena.
+func makeStringArray(mem *arena, v reflect.Value, enc Encoding) unsafe.Pointer {
+	n := v.Len()
+	p := mem.alloc(uintptr(n+1) * unsafe.Sizeof(unsafe.Pointer(nil)))
+	a := unsafe.Slice((*unsafe.Pointer)(p), n+1)
+
+	for i := 0; i != n; i++ {
+		a[i] = mem.encode(v.Index(i).String(), enc)
+	}
+
+	return p
+}
+
+// makeGoStringPointer converts a C string to a pointer to a Go string of type
+// t, a NULL string is converted to a nil pointer.
+func makeGoStringPointer(p *C.char, t reflect.Type) reflect.Value {
+	if p == nil {
+		return reflect.Zero(t)
+	}
+
+	v := reflect.New(t.Elem())
+	v.Elem().SetString(C.GoString(p))
+	return v
+}
+
+// makeGoStrings converts a NULL-terminated array of C strings to a Go slice of
+// type t, a NULL array is converted to a nil slice.
+func makeGoStrings(p **C.char, t reflect.Type) reflect.Value {
+	if p == nil {
+		return reflect.Zero(t)
+	}
+
+	s := reflect.MakeSlice(t, 0, 0)
+
+	for ; *p != nil; p = (**C.char)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		s = reflect.Append(s, reflect.ValueOf(C.GoString(*p)).Convert(t.Elem()))
+	}
+
+	return s
+}
+
 func makeRetType(v reflect.Value) Type {
 	if !v.IsValid() {
 		return Void
 	}
 
+	if t := v.Type().Elem(); isUnmarshaler(t) {
+		if !v.IsNil() {
+			// the type of values like records depends on the instance
+			return v.Interface().(Unmarshaler).FFIType()
+		}
+		return unmarshalerFFIType(t)
+	}
+
+	if m, ok := marshalerOf(v.Elem()); ok {
+		return m.FFIType()
+	}
+
 	switch v.Elem().Kind() {
 	case reflect.Int:
 		return Int
@@ -380,9 +571,7 @@ func makeRetType(v reflect.Value) Type {
 		return UInt64
 
 	case reflect.Uintptr:
-		// Must be what size_t is defined to, on darwin and linux it is a typedef
-		// to 'unsigned long int'.
-		return ULong
+		return SizeT
 
 	case reflect.Float32:
 		return Float
@@ -390,85 +579,49 @@ func makeRetType(v reflect.Value) Type {
 	case reflect.Float64:
 		return Double
 
+	case reflect.Bool:
+		return Bool
+
+	case reflect.Complex64:
+		return ComplexFloat
+
+	case reflect.Complex128:
+		return ComplexDouble
+
 	case reflect.String:
 		return Pointer
 
 	case reflect.UnsafePointer:
 		return Pointer
+
+	case reflect.Ptr:
+		if t := v.Elem().Type(); t == bufferType || t == handleType || t.Elem().Kind() == reflect.String {
+			return Pointer
+		}
 	}
 
 	unsupportedRetType(v)
 	return Type{}
 }
 
-func makeRetValue(v reflect.Value) unsafe.Pointer {
+func makeRetValue(mem *arena, v reflect.Value) unsafe.Pointer {
 	if !v.IsValid() {
 		return nil
 	}
 
-	switch v = v.Elem(); v.Kind() {
-	case reflect.Int:
-		x := C.int(v.Int())
-		return unsafe.Pointer(&x)
-
-	case reflect.Int8:
-		x := C.int8_t(v.Int())
-		return unsafe.Pointer(&x)
-
-	case reflect.Int16:
-		x := C.int16_t(v.Int())
-		return unsafe.Pointer(&x)
-
-	case reflect.Int32:
-		x := C.int32_t(v.Int())
-		return unsafe.Pointer(&x)
-
-	case reflect.Int64:
-		x := C.int64_t(v.Int())
-		return unsafe.Pointer(&x)
-
-	case reflect.Uint8:
-		x := C.uint8_t(v.Uint())
-		return unsafe.Pointer(&x)
-
-	case reflect.Uint16:
-		x := C.uint16_t(v.Uint())
-		return unsafe.Pointer(&x)
-
-	case reflect.Uint32:
-		x := C.uint32_t(v.Uint())
-		return unsafe.Pointer(&x)
-
-	case reflect.Uint64:
-		x := C.uint64_t(v.Uint())
-		return unsafe.Pointer(&x)
-
-	case reflect.Uintptr:
-		x := C.size_t(v.Uint())
-		return unsafe.Pointer(&x)
-
-	case reflect.Float32:
-		x := C.float(v.Float())
-		return unsafe.Pointer(&x)
-
-	case reflect.Float64:
-		x := C.double(v.Float())
-		return unsafe.Pointer(&x)
-
-	case reflect.String:
-		x := unsafe.Pointer(nil)
-		return unsafe.Pointer(&x)
-
-	case reflect.UnsafePointer:
-		x := unsafe.Pointer(nil)
-		return unsafe.Pointer(&x)
+	if _, ok := marshalerOf(v.Elem()); ok || isUnmarshaler(v.Type().Elem()) {
+		return mem.alloc(marshaledSize(makeRetType(v), retSlotSize))
+	}
 
-	case reflect.Ptr:
-		x := unsafe.Pointer(nil)
-		return unsafe.Pointer(&x)
+	switch v.Elem().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.Complex64, reflect.Complex128,
+		reflect.String, reflect.UnsafePointer, reflect.Ptr:
+		return mem.alloc(retSlotSize)
 	}
 
-	unsupportedRetType(v)
+	unsupportedRetType(v.Elem())
 	return nil
 }
 
@@ -482,18 +635,33 @@ func makeArgTypes(v []reflect.Value) []Type {
 	return t
 }
 
-func makeArgValues(v []reflect.Value) []unsafe.Pointer {
-	p := make([]unsafe.Pointer, len(v))
+func makeArgValues(mem *arena, v []reflect.Value) []unsafe.Pointer {
+	if len(v) == 0 {
+		return nil
+	}
+
+	p := unsafe.Slice((*unsafe.Pointer)(mem.alloc(uintptr(len(v))*unsafe.Sizeof(unsafe.Pointer(nil)))), len(v))
 
 	for i, a := range v {
-		p[i] = makeArgValue(a)
+		p[i] = makeArgValue(mem, a)
 	}
 
 	return p
 }
 
 func makeArgType(v reflect.Value) Type {
+	if m, ok := marshalerOf(v); ok {
+		return m.FFIType()
+	}
+
+	if v.IsValid() && v.Kind() != reflect.Ptr && isUnmarshaler(v.Type()) {
+		return unmarshalerFFIType(v.Type())
+	}
+
 	switch v.Kind() {
+	case reflect.Invalid:
+		return Pointer
+
 	case reflect.Int:
 		return Int
 
@@ -525,7 +693,7 @@ func makeArgType(v reflect.Value) Type {
 		return UInt64
 
 	case reflect.Uintptr:
-		return ULong
+		return SizeT
 
 	case reflect.Float32:
 		return Float
@@ -533,6 +701,15 @@ func makeArgType(v reflect.Value) Type {
 	case reflect.Float64:
 		return Double
 
+	case reflect.Bool:
+		return Bool
+
+	case reflect.Complex64:
+		return ComplexFloat
+
+	case reflect.Complex128:
+		return ComplexDouble
+
 	case reflect.String:
 		return Pointer
 
@@ -545,6 +722,11 @@ func makeArgType(v reflect.Value) Type {
 	case reflect.Slice:
 		return Pointer
 
+	case reflect.Struct:
+		if t := v.Type(); t == outType || t == encodedType {
+			return Pointer
+		}
+
 	case reflect.Interface:
 		if v.IsNil() {
 			return Pointer
@@ -555,85 +737,118 @@ func makeArgType(v reflect.Value) Type {
 	return Type{}
 }
 
-func makeArgValue(v reflect.Value) unsafe.Pointer {
+func makeArgValue(mem *arena, v reflect.Value) unsafe.Pointer {
+	if m, ok := marshalerOf(v); ok {
+		p := mem.alloc(marshaledSize(m.FFIType(), argSlotSize))
+		m.MarshalFFI(p)
+		return p
+	}
+
+	p := mem.alloc(argSlotSize)
+
 	switch v.Kind() {
 	case reflect.Int:
-		x := C.int(v.Int())
-		return unsafe.Pointer(&x)
+		*(*C.int)(p) = C.int(v.Int())
 
 	case reflect.Int8:
-		x := C.int8_t(v.Int())
-		return unsafe.Pointer(&x)
+		*(*C.int8_t)(p) = C.int8_t(v.Int())
 
 	case reflect.Int16:
-		x := C.int16_t(v.Int())
-		return unsafe.Pointer(&x)
+		*(*C.int16_t)(p) = C.int16_t(v.Int())
 
 	case reflect.Int32:
-		x := C.int32_t(v.Int())
-		return unsafe.Pointer(&x)
+		*(*C.int32_t)(p) = C.int32_t(v.Int())
 
 	case reflect.Int64:
-		x := C.int64_t(v.Int())
-		return unsafe.Pointer(&x)
+		*(*C.int64_t)(p) = C.int64_t(v.Int())
 
 	case reflect.Uint:
-		x := C.uint(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.uint)(p) = C.uint(v.Uint())
 
 	case reflect.Uint8:
-		x := C.uint8_t(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.uint8_t)(p) = C.uint8_t(v.Uint())
 
 	case reflect.Uint16:
-		x := C.uint16_t(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.uint16_t)(p) = C.uint16_t(v.Uint())
 
 	case reflect.Uint32:
-		x := C.uint32_t(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.uint32_t)(p) = C.uint32_t(v.Uint())
 
 	case reflect.Uint64:
-		x := C.uint64_t(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.uint64_t)(p) = C.uint64_t(v.Uint())
 
 	case reflect.Uintptr:
-		x := C.size_t(v.Uint())
-		return unsafe.Pointer(&x)
+		*(*C.size_t)(p) = C.size_t(v.Uint())
 
 	case reflect.Float32:
-		x := C.float(v.Float())
-		return unsafe.Pointer(&x)
+		*(*C.float)(p) = C.float(v.Float())
 
 	case reflect.Float64:
-		x := C.double(v.Float())
-		return unsafe.Pointer(&x)
+		*(*C.double)(p) = C.double(v.Float())
+
+	case reflect.Bool:
+		*(*C._Bool)(p) = C._Bool(v.Bool())
+
+	case reflect.Complex64:
+		*(*C.complexfloat)(p) = C.complexfloat(v.Complex())
+
+	case reflect.Complex128:
+		*(*C.complexdouble)(p) = C.complexdouble(v.Complex())
 
 	case reflect.String:
-		x := C.CString(v.String())
-		return unsafe.Pointer(&x)
+		*(*unsafe.Pointer)(p) = mem.cstring(v.String())
 
-	case reflect.UnsafePointer:
-		x := v.Pointer()
-		return unsafe.Pointer(&x)
+	case reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+
+		if v.Type().Elem().Kind() == reflect.String {
+			*(*unsafe.Pointer)(p) = makeStringArray(mem, v, UTF8)
+		} else {
+			*(*unsafe.Pointer)(p) = mem.pin(v.UnsafePointer())
+		}
 
 	case reflect.Ptr:
-		x := v.Pointer()
-		return unsafe.Pointer(&x)
+		if v.IsNil() {
+			break
+		}
 
-	case reflect.Slice:
-		x := v.Pointer()
-		return unsafe.Pointer(&x)
+		if m, ok := cMemoryOf(v); ok {
+			*(*unsafe.Pointer)(p) = m.cPointer()
+		} else if v.Type().Elem().Kind() == reflect.String {
+			*(*unsafe.Pointer)(p) = mem.cstring(v.Elem().String())
+		} else {
+			*(*unsafe.Pointer)(p) = mem.pin(v.UnsafePointer())
+		}
+
+	case reflect.UnsafePointer:
+		*(*unsafe.Pointer)(p) = mem.pin(v.UnsafePointer())
+
+	case reflect.Invalid:
+		// untyped nil arguments are passed as NULL pointers
+
+	case reflect.Struct:
+		switch v.Type() {
+		case outType:
+			*(*unsafe.Pointer)(p) = makeOutValue(mem, v)
+		case encodedType:
+			v, enc := valueOfEncoded(v)
+			makeEncodedValue(mem, v, enc, p)
+		default:
+			unsupportedArgType(v)
+		}
 
 	case reflect.Interface:
-		if v.IsNil() {
-			x := unsafe.Pointer(nil)
-			return unsafe.Pointer(&x)
+		if !v.IsNil() {
+			unsupportedArgType(v)
 		}
+
+	default:
+		unsupportedArgType(v)
 	}
 
-	unsupportedArgType(v)
-	return nil
+	return p
 }
 
 func setRetValue(v reflect.Value, p unsafe.Pointer) {
@@ -641,6 +856,11 @@ func setRetValue(v reflect.Value, p unsafe.Pointer) {
 		return
 	}
 
+	if isUnmarshaler(v.Type().Elem()) {
+		v.Interface().(Unmarshaler).UnmarshalFFI(p)
+		return
+	}
+
 	switch v = v.Elem(); v.Kind() {
 	case reflect.Int:
 		v.SetInt(int64(*((*C.int)(p))))
@@ -681,15 +901,34 @@ func setRetValue(v reflect.Value, p unsafe.Pointer) {
 	case reflect.Float64:
 		v.SetFloat(float64(*(*C.double)(p)))
 
+	case reflect.Bool:
+		v.SetBool(bool(*(*C._Bool)(p)))
+
+	case reflect.Complex64:
+		v.SetComplex(complex128(*(*C.complexfloat)(p)))
+
+	case reflect.Complex128:
+		v.SetComplex(complex128(*(*C.complexdouble)(p)))
+
 	case reflect.String:
 		v.SetString(C.GoString(*(**C.char)(p)))
 
 	case reflect.UnsafePointer:
 		v.SetPointer(*(*unsafe.Pointer)(p))
+
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() == reflect.String {
+			v.Set(makeGoStringPointer(*(**C.char)(p), v.Type()))
+		}
 	}
 }
 
 func setRetPointer(p unsafe.Pointer, v reflect.Value) {
+	if m, ok := marshalerOf(v); ok {
+		m.MarshalFFI(p)
+		return
+	}
+
 	switch v.Kind() {
 	case reflect.Int:
 		*((*C.int)(p)) = C.int(v.Int())
@@ -730,11 +969,29 @@ func setRetPointer(p unsafe.Pointer, v reflect.Value) {
 	case reflect.Float64:
 		*((*C.double)(p)) = C.double(v.Float())
 
+	case reflect.Bool:
+		*((*C._Bool)(p)) = C._Bool(v.Bool())
+
+	case reflect.Complex64:
+		*((*C.complexfloat)(p)) = C.complexfloat(v.Complex())
+
+	case reflect.Complex128:
+		*((*C.complexdouble)(p)) = C.complexdouble(v.Complex())
+
 	case reflect.String:
 		*((**C.char)(p)) = C.CString(v.String())
 
 	case reflect.UnsafePointer:
 		*((*unsafe.Pointer)(p)) = unsafe.Pointer(v.Pointer())
+
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() == reflect.String {
+			if v.IsNil() {
+				*((**C.char)(p)) = nil
+			} else {
+				*((**C.char)(p)) = C.CString(v.Elem().String())
+			}
+		}
 	}
 }
 
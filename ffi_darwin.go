@@ -1,9 +1,12 @@
+//go:build !nolibffi
+
 package ffi
 
 // #cgo CFLAGS: -I/usr/include/ffi
 // #cgo LDFLAGS: -lffi
 //
 // #include <ffi.h>
+// #include <stdint.h>
 // #include <unistd.h>
 // #include <sys/mman.h>
 //
@@ -32,6 +35,10 @@ package ffi
 //
 // typedef  void (*closure)(ffi_cif*, void*, void**, void*);
 //
+// static ffi_status ffi_prep_closure__(ffi_closure *c, ffi_cif *cif, uintptr_t handle) {
+//   return ffi_prep_closure(c, cif, (closure)GoClosureCallback, (void *) handle);
+// }
+//
 import "C"
 import "unsafe"
 
@@ -43,7 +50,7 @@ func constructClosure(fn *function) (err error) {
 		return
 	}
 
-	if status := Status(C.ffi_prep_closure((*C.ffi_closure)(ptr), &fn.Interface.ffi_cif, C.closure(C.GoClosureCallback), unsafe.Pointer(fn))); status != OK {
+	if status := Status(C.ffi_prep_closure__((*C.ffi_closure)(ptr), fn.ffi.cif, C.uintptr_t(fn.handle))); status != OK {
 		C.ffi_closure_free__(ptr)
 		err = status
 		return
@@ -1,12 +1,19 @@
+//go:build !nolibffi
+
 package ffi
 
 // #cgo LDFLAGS: -lffi
 //
 // #include <ffi.h>
+// #include <stdint.h>
 //
 // extern void GoClosureCallback(ffi_cif *, void *, void **, void *);
 //
 // typedef void (*closure)(ffi_cif *, void *, void **, void *);
+//
+// static ffi_status ffi_prep_closure__(ffi_closure *c, ffi_cif *cif, uintptr_t handle, void *fptr) {
+//   return ffi_prep_closure_loc(c, cif, (closure)GoClosureCallback, (void *) handle, fptr);
+// }
 import "C"
 import "unsafe"
 
@@ -19,7 +26,7 @@ func constructClosure(fn *function) (err error) {
 		return
 	}
 
-	if status := Status(C.ffi_prep_closure_loc((*C.ffi_closure)(mptr), &fn.Interface.ffi_cif, C.closure(C.GoClosureCallback), unsafe.Pointer(fn), fptr)); status != OK {
+	if status := Status(C.ffi_prep_closure__((*C.ffi_closure)(mptr), fn.ffi.cif, C.uintptr_t(fn.handle), fptr)); status != OK {
 		C.ffi_closure_free(mptr)
 		err = status
 		return
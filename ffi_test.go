@@ -1,11 +1,19 @@
 package ffi
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 	"unsafe"
 
 	"github.com/achille-roussel/go-dl"
@@ -15,10 +23,25 @@ var (
 	libc     dl.Library
 	libm     dl.Library
 	abs      uintptr
+	cabs     uintptr
+	cabsf    uintptr
+	div      uintptr
+	expl     uintptr
 	fabs     uintptr
 	fabsf    uintptr
+	fmax     uintptr
+	frexp    uintptr
+	memalign uintptr
+	qsort    uintptr
 	snprintf uintptr
+	strdup   uintptr
 	strerror uintptr
+	strnlen  uintptr
+	strtol   uintptr
+	strtold  uintptr
+	timegm   uintptr
+	wcschr   uintptr
+	wcslen   uintptr
 )
 
 func TestVoidTypeString(t *testing.T) {
@@ -101,6 +124,22 @@ func TestPointerTypeString(t *testing.T) {
 	testTypeString(t, Pointer, "void *")
 }
 
+func TestBoolTypeString(t *testing.T) {
+	testTypeString(t, Bool, "_Bool")
+}
+
+func TestSizeTTypeString(t *testing.T) {
+	testTypeString(t, SizeT, "size_t")
+}
+
+func TestComplexDoubleTypeString(t *testing.T) {
+	testTypeString(t, ComplexDouble, "double _Complex")
+}
+
+func TestLongDoubleTypeString(t *testing.T) {
+	testTypeString(t, LongDoubleType, "long double")
+}
+
 func TestDefaultTypeString(t *testing.T) {
 	testTypeString(t, Type{}, "struct")
 }
@@ -424,6 +463,25 @@ func TestCallAbsClosure(t *testing.T) {
 	}
 }
 
+func TestCallClosureAfterGC(t *testing.T) {
+	abs := ClosureOf(func(x int32) int32 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	})
+
+	cif := Prepare(Int32, Int32)
+	runtime.GC()
+	runtime.GC()
+
+	if res := Call1[int32](&cif, unsafe.Pointer(abs.Pointer()), int32(-3)); res != 3 {
+		t.Error("closure: invalid returned value:", res)
+	}
+
+	runtime.KeepAlive(abs)
+}
+
 func TestCallItoaClosure(t *testing.T) {
 	itoa := Closure(strconv.Itoa)
 
@@ -440,83 +498,2196 @@ func TestCallItoaClosure(t *testing.T) {
 	}
 }
 
-func init() {
-	var err error
+func TestCall1Abs(t *testing.T) {
+	cif := Prepare(Int, Int)
 
-	if libc, err = load("libc"); err != nil {
-		panic(err)
+	if ret := Call1[int32](&cif, unsafe.Pointer(abs), int32(-1)); ret != 1 {
+		t.Error("abs:", ret)
 	}
+}
 
-	if libm, err = load("libm"); err != nil {
-		panic(err)
+func TestCall1Fabs(t *testing.T) {
+	cif := Prepare(Double, Double)
+
+	if ret := Call1[float64](&cif, unsafe.Pointer(fabs), -0.5); ret != 0.5 {
+		t.Error("fabs:", ret)
 	}
+}
 
-	abs = symbol(libc, "abs")
-	fabs = symbol(libm, "fabs")
-	fabsf = symbol(libm, "fabsf")
-	snprintf = symbol(libc, "snprintf")
-	strerror = symbol(libc, "strerror")
+func TestCall1Fabsf(t *testing.T) {
+	cif := Prepare(Float, Float)
+
+	if ret := Call1[float32](&cif, unsafe.Pointer(fabsf), float32(-0.5)); ret != 0.5 {
+		t.Error("fabsf:", ret)
+	}
 }
 
-func load(name string) (lib dl.Library, err error) {
-	var path string
+func TestCall1StrerrorReturnPointer(t *testing.T) {
+	cif := Prepare(Pointer, Int)
 
-	if path, err = dl.Find(name); err != nil {
-		return
+	if ret := Call1[unsafe.Pointer](&cif, unsafe.Pointer(strerror), int32(syscall.ENOENT)); ret == nil {
+		t.Error("strerror:", ret)
 	}
+}
 
-	if lib, err = dl.Open(path, 0); err != nil {
-		return
+func TestCall4Snprintf(t *testing.T) {
+	buf := make([]byte, 16)
+	fmt := []byte("%d\x00")
+	cif := Prepare(Int, Pointer, ULong, Pointer, Int)
+	res := Call4[int32](&cif, unsafe.Pointer(snprintf), unsafe.Pointer(&buf[0]), uintptr(len(buf)), unsafe.Pointer(&fmt[0]), int32(42))
+
+	if res != 2 {
+		t.Error("snprintf: invalid return value:", res)
 	}
 
-	return
+	if s := string(buf[:res]); s != "42" {
+		t.Error("snprintf: invalid formatted string:", s)
+	}
 }
 
-func symbol(lib dl.Library, name string) (addr uintptr) {
-	var err error
+func TestCheckedCallNestedPointer(t *testing.T) {
+	type node struct {
+		N    int
+		Next *node
+	}
 
-	if addr, err = lib.Symbol(name); err != nil {
-		panic(err)
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
+
+	err := Call(unsafe.Pointer(abs), nil, &node{Next: &node{}})
+
+	if e, ok := err.(*PointerError); !ok {
+		t.Error("checked call: expected pointer error but got", err)
+	} else if e.Index != 0 || e.Path != "(*arg).Next" {
+		t.Error("checked call: invalid pointer error:", e)
 	}
+}
 
-	return
+func TestCheckedCallSliceOfStrings(t *testing.T) {
+	type item struct {
+		ID   int
+		Name string
+	}
+
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
+
+	items := []item{{ID: 1}, {ID: 2, Name: strings.Repeat("x", 10)}}
+	err := Call(unsafe.Pointer(abs), nil, 0, items)
+
+	if e, ok := err.(*PointerError); !ok {
+		t.Error("checked call: expected pointer error but got", err)
+	} else if e.Index != 1 || e.Path != "arg[1].Name" {
+		t.Error("checked call: invalid pointer error:", e)
+	}
 }
 
-func BenchmarkCallingAbsViaCgo(b *testing.B) {
-	for i, n := 0, b.N; i != n; i++ {
-		ffi_test_abs__(-i)
+func TestCheckedCallPinned(t *testing.T) {
+	type node struct {
+		N    int
+		Next *node
+	}
+
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	arg := &node{Next: &node{}}
+	pinner.Pin(arg.Next)
+
+	if err := Call(unsafe.Pointer(abs), nil, arg); err != nil {
+		t.Error("checked call:", err)
 	}
 }
 
-func BenchmarkCallingAbsViaInterface(b *testing.B) {
-	cif := Prepare(Int, Int)
+func TestCheckedCallSnprintf(t *testing.T) {
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
 
-	for i, n := 0, b.N; i != n; i++ {
-		arg := -i
-		res := 0
-		cif.Call(unsafe.Pointer(abs), unsafe.Pointer(&res), unsafe.Pointer(&arg))
+	res := 0
+	buf := make([]byte, 16)
+
+	if err := Call(unsafe.Pointer(snprintf), &res, buf, uintptr(len(buf)), "%d", 42); err != nil {
+		t.Error("checked call:", err)
+	} else if s := string(buf[:res]); s != "42" {
+		t.Error("snprintf: invalid formatted string:", s)
 	}
 }
 
-func BenchmarkCallingAbsViaCall(b *testing.B) {
-	for i, n := 0, b.N; i != n; i++ {
-		arg := -1
+func TestCheckedCallPinnedArgument(t *testing.T) {
+	type buffer struct {
+		Data *byte
+		Size int
+	}
+
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
+
+	data := make([]byte, 32)
+	args := []*buffer{{Data: &data[0], Size: len(data)}}
+
+	if err := Call(unsafe.Pointer(abs), nil, args); err == nil {
+		t.Error("checked call: expected pointer error")
+	}
+
+	if err := Call(unsafe.Pointer(abs), nil, Pinned(args)); err != nil {
+		t.Error("checked call:", err)
+	}
+
+	if err := Call(unsafe.Pointer(abs), nil, args[0]); err == nil {
+		t.Error("checked call: memory still pinned after the call")
+	}
+}
+
+func TestCheckedCallPins(t *testing.T) {
+	defer func(checked bool) { Checked = checked }(Checked)
+	Checked = true
+
+	var pins Pins
+	strs := []string{strings.Repeat("a", 10), strings.Repeat("b", 10)}
+	ptrs := []*byte{unsafe.StringData(strs[0]), unsafe.StringData(strs[1])}
+
+	if err := Call(unsafe.Pointer(abs), nil, pins.Pin(ptrs)); err != nil {
+		t.Error("checked call:", err)
+	}
+
+	if err := Call(unsafe.Pointer(abs), nil, ptrs); err != nil {
+		t.Error("checked call: memory unpinned before release:", err)
+	}
+
+	pins.Release()
+
+	if err := Call(unsafe.Pointer(abs), nil, ptrs); err == nil {
+		t.Error("checked call: memory still pinned after release")
+	}
+}
+
+func TestCallSnprintfStrings(t *testing.T) {
+	buf := make([]byte, 4096)
+
+	for _, n := range []int{0, 10, 1000, 3000, 5} {
 		res := 0
-		Call(unsafe.Pointer(abs), &res, arg)
+		str := strings.Repeat("x", n)
+		Call(unsafe.Pointer(snprintf), &res, buf, uintptr(len(buf)), "%s-%s", str, "end")
+
+		if s := string(buf[:res]); s != str+"-end" {
+			t.Error("snprintf: invalid formatted string of length", len(s))
+		}
 	}
 }
 
-func BenchmarkCallingAbsViaClosure(b *testing.B) {
-	abs := Closure(func(n int) int {
-		if n < 0 {
-			return -n
+func TestCall1AbsAllocs(t *testing.T) {
+	cif := Prepare(Int, Int)
+	arg := int32(-1)
+
+	if n := testing.AllocsPerRun(100, func() { Call1[int32](&cif, unsafe.Pointer(abs), arg) }); n != 0 {
+		t.Error("abs: allocations per call:", n)
+	}
+}
+
+func TestCall1FabsAllocs(t *testing.T) {
+	cif := Prepare(Double, Double)
+	arg := -0.5
+
+	if n := testing.AllocsPerRun(100, func() { Call1[float64](&cif, unsafe.Pointer(fabs), arg) }); n != 0 {
+		t.Error("fabs: allocations per call:", n)
+	}
+}
+
+func TestCall4SnprintfAllocs(t *testing.T) {
+	buf := make([]byte, 16)
+	fmt := []byte("%d\x00")
+	cif := Prepare(Int, Pointer, ULong, Pointer, Int)
+
+	if n := testing.AllocsPerRun(100, func() {
+		Call4[int32](&cif, unsafe.Pointer(snprintf), unsafe.Pointer(&buf[0]), uintptr(len(buf)), unsafe.Pointer(&fmt[0]), int32(123456))
+	}); n != 0 {
+		t.Error("snprintf: allocations per call:", n)
+	}
+}
+
+func TestFrameCallAbs(t *testing.T) {
+	frame := Prepare(Int, Int).NewFrame()
+
+	for _, test := range []struct{ arg, ret int64 }{{-1, 1}, {2, 2}, {-3, 3}} {
+		frame.SetInt(0, test.arg)
+		frame.Call(unsafe.Pointer(abs))
+
+		if ret := frame.Int(); ret != test.ret {
+			t.Errorf("abs(%d): %d != %d", test.arg, ret, test.ret)
 		}
-		return n
-	})
+	}
+}
 
-	for i, n := 0, b.N; i != n; i++ {
-		arg := -i
-		res := 0
-		abs.Call(unsafe.Pointer(&res), unsafe.Pointer(&arg))
+func TestFrameCallFabs(t *testing.T) {
+	frame := Prepare(Double, Double).NewFrame()
+	frame.SetDouble(0, -0.5)
+	frame.Call(unsafe.Pointer(fabs))
+
+	if ret := frame.Double(); ret != 0.5 {
+		t.Error("fabs:", ret)
+	}
+}
+
+func TestFrameCallFabsf(t *testing.T) {
+	frame := Prepare(Float, Float).NewFrame()
+	frame.SetFloat(0, -0.5)
+	frame.Call(unsafe.Pointer(fabsf))
+
+	if ret := frame.Float(); ret != 0.5 {
+		t.Error("fabsf:", ret)
+	}
+}
+
+func TestFrameCallStrerrorReturnPointer(t *testing.T) {
+	frame := Prepare(Pointer, Int).NewFrame()
+	frame.SetInt(0, int64(syscall.ENOENT))
+	frame.Call(unsafe.Pointer(strerror))
+
+	if ret := frame.Pointer(); ret == nil {
+		t.Error("strerror:", ret)
+	}
+}
+
+func TestFrameInvalidArgumentIndex(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+
+	Prepare(Int, Int).NewFrame().SetInt(1, 0)
+
+	t.Error("unreachable: out of range argument index should have caused Frame.SetInt to panic")
+}
+
+func TestFrameCallAbsAllocs(t *testing.T) {
+	frame := Prepare(Int, Int).NewFrame()
+
+	if n := testing.AllocsPerRun(100, func() {
+		frame.SetInt(0, -1)
+		frame.Call(unsafe.Pointer(abs))
+		frame.Int()
+	}); n != 0 {
+		t.Error("abs: allocations per call:", n)
+	}
+}
+
+func TestBatchRunAbs(t *testing.T) {
+	cif := Prepare(Int, Int)
+	arg := []int{-1, 2, -3, 4}
+	res := make([]int, len(arg))
+	b := &Batch{}
+
+	for i := range arg {
+		b.Add(cif, unsafe.Pointer(abs), unsafe.Pointer(&res[i]), unsafe.Pointer(&arg[i]))
+	}
+
+	if n := b.Len(); n != len(arg) {
+		t.Error("batch: invalid length:", n)
+	}
+
+	b.Run()
+
+	for i, r := range res {
+		if r != i+1 {
+			t.Error("batch: abs:", i, r)
+		}
+	}
+}
+
+func TestBatchRunMixed(t *testing.T) {
+	b := &Batch{}
+	x := -0.5
+	y := float32(-1.5)
+	z := -42
+	rx := 0.0
+	ry := float32(0.0)
+	rz := 0
+
+	b.Add(Prepare(Double, Double), unsafe.Pointer(fabs), unsafe.Pointer(&rx), unsafe.Pointer(&x))
+	b.Add(Prepare(Float, Float), unsafe.Pointer(fabsf), unsafe.Pointer(&ry), unsafe.Pointer(&y))
+	b.Add(Prepare(Int, Int), unsafe.Pointer(abs), unsafe.Pointer(&rz), unsafe.Pointer(&z))
+	b.Add(Prepare(Int, Int), unsafe.Pointer(abs), nil, unsafe.Pointer(&z))
+	b.Run()
+
+	if rx != 0.5 {
+		t.Error("batch: fabs:", rx)
+	}
+
+	if ry != 1.5 {
+		t.Error("batch: fabsf:", ry)
+	}
+
+	if rz != 42 {
+		t.Error("batch: abs:", rz)
+	}
+}
+
+func TestBatchRunNarrowReturn(t *testing.T) {
+	b := &Batch{}
+	arg := int32(-7)
+	res := struct {
+		ret   int32
+		guard int32
+	}{guard: 0x7777}
+
+	b.Add(Prepare(Int32, Int32), unsafe.Pointer(abs), unsafe.Pointer(&res.ret), unsafe.Pointer(&arg))
+	b.Run()
+
+	if res.ret != 7 {
+		t.Error("batch: abs:", res.ret)
+	}
+
+	if res.guard != 0x7777 {
+		t.Errorf("batch: return value overran its pointer: %#x", res.guard)
+	}
+}
+
+func TestBatchArgumentsCopiedOnAdd(t *testing.T) {
+	b := &Batch{}
+	arg := -1
+	res := 0
+
+	b.Add(Prepare(Int, Int), unsafe.Pointer(abs), unsafe.Pointer(&res), unsafe.Pointer(&arg))
+	arg = -2
+	b.Run()
+
+	if res != 1 {
+		t.Error("batch: abs:", res)
+	}
+}
+
+func TestBatchReset(t *testing.T) {
+	b := &Batch{}
+	arg := -1
+	res := 0
+
+	b.Add(Prepare(Int, Int), unsafe.Pointer(abs), unsafe.Pointer(&res), unsafe.Pointer(&arg))
+	b.Reset()
+
+	if n := b.Len(); n != 0 {
+		t.Error("batch: invalid length after reset:", n)
+	}
+
+	b.Run()
+
+	if res != 0 {
+		t.Error("batch: call was run after reset:", res)
+	}
+}
+
+func TestBatchInvalidArgumentCount(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+
+	res := 0
+	(&Batch{}).Add(Prepare(Int, Int), unsafe.Pointer(abs), unsafe.Pointer(&res))
+
+	t.Error("unreachable: missing argument should have caused Batch.Add to panic")
+}
+
+func TestMapFabs(t *testing.T) {
+	in := []float64{-0.5, 1.5, -2.5}
+	out := make([]float64, len(in))
+
+	Map(Prepare(Double, Double), unsafe.Pointer(fabs), out, in)
+
+	for i, x := range []float64{0.5, 1.5, 2.5} {
+		if out[i] != x {
+			t.Error("map: fabs:", i, out[i])
+		}
+	}
+}
+
+func TestMapFabsf(t *testing.T) {
+	in := []float32{-0.5, 1.5, -2.5}
+	out := make([]float32, len(in))
+
+	Map(Prepare(Float, Float), unsafe.Pointer(fabsf), out, in)
+
+	for i, x := range []float32{0.5, 1.5, 2.5} {
+		if out[i] != x {
+			t.Error("map: fabsf:", i, out[i])
+		}
+	}
+}
+
+func TestMapAbs(t *testing.T) {
+	in := []int{-1, 2, -3}
+	out := make([]int, len(in))
+
+	Map(Prepare(Int, Int), unsafe.Pointer(abs), out, in)
+
+	for i, x := range []int{1, 2, 3} {
+		if out[i] != x {
+			t.Error("map: abs:", i, out[i])
+		}
+	}
+}
+
+func TestMapAbsInt32(t *testing.T) {
+	in := []int32{-1, 2, -3}
+	out := make([]int32, len(in)+1)
+	out[len(in)] = 42
+
+	Map(Prepare(Int, Int), unsafe.Pointer(abs), out[:len(in)], in)
+
+	for i, x := range []int32{1, 2, 3, 42} {
+		if out[i] != x {
+			t.Error("map: abs:", i, out[i])
+		}
+	}
+}
+
+func TestMapFmax(t *testing.T) {
+	in1 := []float64{1, 5, -3}
+	in2 := []float64{2, 4, -6}
+	out := make([]float64, len(in1))
+
+	Map(Prepare(Double, Double, Double), unsafe.Pointer(fmax), out, in1, in2)
+
+	for i, x := range []float64{2, 5, -3} {
+		if out[i] != x {
+			t.Error("map: fmax:", i, out[i])
+		}
+	}
+}
+
+func TestMapEmpty(t *testing.T) {
+	Map(Prepare(Double, Double), unsafe.Pointer(fabs), []float64{}, []float64{})
+}
+
+func TestMapInvalidElementType(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+
+	Map(Prepare(Double, Double), unsafe.Pointer(fabs), make([]float64, 1), []float32{-1})
+
+	t.Error("unreachable: mismatching element type should have caused ffi.Map to panic")
+}
+
+func TestMapInvalidLength(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+
+	Map(Prepare(Double, Double), unsafe.Pointer(fabs), make([]float64, 2), []float64{-1})
+
+	t.Error("unreachable: mismatching slice lengths should have caused ffi.Map to panic")
+}
+
+func TestMapInvalidOutput(t *testing.T) {
+	defer func() {
+		recover()
+	}()
+
+	Map(Prepare(Double, Double), unsafe.Pointer(fabs), 0.0, []float64{-1})
+
+	t.Error("unreachable: non-slice output should have caused ffi.Map to panic")
+}
+
+func TestCallClosureOfAdd(t *testing.T) {
+	add := ClosureOf(func(a int32, b int32) int32 { return a + b })
+	cif := Prepare(Int32, Int32, Int32)
+
+	if res := Call2[int32](&cif, unsafe.Pointer(add.Pointer()), int32(40), int32(2)); res != 42 {
+		t.Error("closure: invalid returned value:", res)
+	}
+}
+
+func TestCallClosureOfAbs(t *testing.T) {
+	abs := ClosureOf(func(x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	})
+
+	res := 0
+	err := Call(unsafe.Pointer(abs.Pointer()), &res, -1)
+
+	if err != nil {
+		t.Error("closure:", err)
+	}
+
+	if res != 1 {
+		t.Error("closure: invalid returned value:", res)
+	}
+}
+
+func TestCallClosureOfFabs(t *testing.T) {
+	fabs := ClosureOf(math.Abs)
+	res := 0.0
+	err := Call(unsafe.Pointer(fabs.Pointer()), &res, -0.5)
+
+	if err != nil {
+		t.Error("closure:", err)
+	}
+
+	if res != 0.5 {
+		t.Error("closure: invalid returned value:", res)
+	}
+}
+
+func TestCallQsortWithClosure(t *testing.T) {
+	cmp := Closure(func(a unsafe.Pointer, b unsafe.Pointer) int32 {
+		return *(*int32)(a) - *(*int32)(b)
+	})
+
+	arr := []int32{3, 1, 2}
+	err := Call(unsafe.Pointer(qsort), nil, &arr[0], uintptr(len(arr)), uintptr(4), unsafe.Pointer(cmp.Pointer()))
+
+	if err != nil {
+		t.Error("qsort:", err)
+	}
+
+	for i, x := range []int32{1, 2, 3} {
+		if arr[i] != x {
+			t.Error("qsort: invalid sorted array:", arr)
+			break
+		}
+	}
+}
+
+func TestCallQsortWithReflectClosure(t *testing.T) {
+	cmp := Closure(func(a unsafe.Pointer, b unsafe.Pointer) int8 {
+		return int8(*(*int32)(a) - *(*int32)(b))
+	})
+
+	arr := []int32{3, 1, 2}
+	err := Call(unsafe.Pointer(qsort), nil, &arr[0], uintptr(len(arr)), uintptr(4), unsafe.Pointer(cmp.Pointer()))
+
+	if err != nil {
+		t.Error("qsort:", err)
+	}
+
+	for i, x := range []int32{1, 2, 3} {
+		if arr[i] != x {
+			t.Error("qsort: invalid sorted array:", arr)
+			break
+		}
+	}
+}
+
+func TestClosureUnsafePointerParameter(t *testing.T) {
+	var got [2]unsafe.Pointer
+
+	fast := Closure(func(p unsafe.Pointer) int { got[0] = p; return 0 })
+	slow := Closure(func(p unsafe.Pointer, n int32) int32 { got[1] = p; return n })
+
+	arg := Malloc(8)
+	defer Free(arg)
+
+	res := 0
+	Call(unsafe.Pointer(fast.Pointer()), &res, arg)
+	Call(unsafe.Pointer(slow.Pointer()), &res, arg, int32(1))
+
+	for i, p := range got {
+		if p != arg {
+			t.Errorf("closure %d: received %p instead of the pointer argument %p", i, p, arg)
+		}
+	}
+}
+
+func TestCallClosureOfAllocs(t *testing.T) {
+	add := ClosureOf(func(a int32, b int32) int32 { return a + b })
+	cif := Prepare(Int32, Int32, Int32)
+	ptr := unsafe.Pointer(add.Pointer())
+
+	if n := testing.AllocsPerRun(100, func() { Call2[int32](&cif, ptr, int32(40), int32(2)) }); n != 0 {
+		t.Error("closure: allocations per call:", n)
+	}
+}
+
+func TestMallocFree(t *testing.T) {
+	p := Malloc(16)
+
+	if p == nil {
+		t.Fatal("malloc: null pointer")
+	}
+
+	s := SliceOf[int32](p, 4)
+	for i := range s {
+		s[i] = int32(i)
+	}
+
+	if v := SliceOf[int32](unsafe.Add(p, 12), 1)[0]; v != 3 {
+		t.Error("malloc: invalid value:", v)
+	}
+
+	Free(p)
+}
+
+func TestCallocZeroed(t *testing.T) {
+	p := Calloc(8, 8)
+	defer Free(p)
+
+	for i, v := range SliceOf[uint64](p, 8) {
+		if v != 0 {
+			t.Error("calloc: non-zero value at index", i)
+		}
+	}
+}
+
+func TestMemcpy(t *testing.T) {
+	src := []byte("Hello World!")
+	dst := Malloc(uintptr(len(src)))
+	defer Free(dst)
+
+	Memcpy(dst, unsafe.Pointer(&src[0]), uintptr(len(src)))
+
+	if s := string(SliceOf[byte](dst, len(src))); s != "Hello World!" {
+		t.Error("memcpy: invalid content:", s)
+	}
+}
+
+func TestBufferSnprintf(t *testing.T) {
+	buf := NewBuffer(16)
+	defer buf.Free()
+
+	res := 0
+	Call(unsafe.Pointer(snprintf), &res, buf.Pointer(), uintptr(buf.Len()), "%d", 42)
+
+	if s := string(buf.Bytes()[:res]); s != "42" {
+		t.Error("snprintf: invalid formatted string:", s)
+	}
+}
+
+func TestBufferArgument(t *testing.T) {
+	buf := NewBuffer(16)
+	defer buf.Free()
+
+	res := 0
+	Call(unsafe.Pointer(snprintf), &res, buf, uintptr(buf.Len()), "%d", 42)
+
+	if s := string(buf.Bytes()[:res]); s != "42" {
+		t.Error("snprintf: invalid formatted string:", s)
+	}
+
+	n := uintptr(0)
+	Call(unsafe.Pointer(strnlen), &n, buf, uintptr(buf.Len()))
+
+	if n != 2 {
+		t.Error("strnlen: invalid length of buffer:", n)
+	}
+}
+
+func TestBufferOf(t *testing.T) {
+	buf := BufferOf([]byte("abc"))
+
+	if s := string(buf.Bytes()); s != "abc" {
+		t.Error("buffer: invalid content:", s)
+	}
+
+	buf.Free()
+	buf.Free()
+
+	if buf.Pointer() != nil || buf.Len() != 0 || buf.Bytes() != nil {
+		t.Error("buffer: not released after free")
+	}
+}
+
+func TestCallStrdupFreed(t *testing.T) {
+	var s string
+
+	if err := Call(unsafe.Pointer(strdup), Return(&s, Freed), "Hello World!"); err != nil {
+		t.Error("strdup:", err)
+	}
+
+	if s != "Hello World!" {
+		t.Error("strdup: invalid returned string:", s)
+	}
+}
+
+func TestCallStrdupFreedWith(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	var s string
+	Call(unsafe.Pointer(strdup), Return(&s, FreedWith(unsafe.Pointer(free.Pointer()))), "Hello World!")
+
+	if s != "Hello World!" {
+		t.Error("strdup: invalid returned string:", s)
+	}
+
+	if freed == nil {
+		t.Error("strdup: returned string was not released")
+	}
+}
+
+func TestCallStrdupBuffer(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	var b *Buffer
+	Call(unsafe.Pointer(strdup), Return(&b, FreedWith(unsafe.Pointer(free.Pointer()))), "abc")
+
+	if b == nil || b.Pointer() == nil {
+		t.Fatal("strdup: null buffer")
+	}
+
+	if s := string(SliceOf[byte](b.Pointer(), 4)); s != "abc\x00" {
+		t.Errorf("strdup: invalid buffer content: %q", s)
+	}
+
+	p := b.Pointer()
+	b.Free()
+
+	if freed != p {
+		t.Error("strdup: buffer memory was not released")
+	}
+}
+
+func TestCallOwnedInvalidReturn(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("ownership of an int return value was accepted")
+		}
+	}()
+
+	var n int
+	Call(unsafe.Pointer(abs), Return(&n, Freed), -1)
+}
+
+func TestHandleClose(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	var h *Handle
+	Call(unsafe.Pointer(strdup), Return(&h, FreedWith(unsafe.Pointer(free.Pointer()))), "abc")
+
+	if h == nil {
+		t.Fatal("strdup: null handle")
+	}
+
+	p := h.Get()
+
+	if err := h.Close(); err != nil {
+		t.Error("handle: close:", err)
+	}
+
+	if freed != p {
+		t.Error("handle: destructor was not called")
+	}
+
+	if err := h.Close(); err != ErrClosed {
+		t.Error("handle: closing twice:", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("handle: use after close did not panic")
+		}
+	}()
+
+	h.Get()
+}
+
+func TestBindStrdupFreedWith(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	cif := Prepare(Pointer, Pointer).WithReturn(FreedWith(unsafe.Pointer(free.Pointer())))
+	dup := Bind[func(string) string](cif, unsafe.Pointer(strdup))
+
+	if s := dup("Hello World!"); s != "Hello World!" {
+		t.Error("strdup: invalid returned string:", s)
+	}
+
+	if freed == nil {
+		t.Error("strdup: returned string was not released")
+	}
+}
+
+func TestBindStrdupFreedBy(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := Closure(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	dup := Bind[func(string) *Handle](Prepare(Pointer, Pointer).WithReturn(FreedBy(free)), unsafe.Pointer(strdup))
+	h := dup("abc")
+	p := h.Get()
+	h.Close()
+
+	if freed != p {
+		t.Error("handle: destructor given as a function was not called")
+	}
+}
+
+func TestBindHandleClose(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	cif := Prepare(Pointer, Pointer).WithReturn(FreedWith(unsafe.Pointer(free.Pointer())))
+	open := Bind[func(string) *Handle](cif, unsafe.Pointer(strdup))
+
+	h := open("abc")
+
+	if h == nil {
+		t.Fatal("strdup: null handle")
+	}
+
+	p := h.Get()
+
+	if err := h.Close(); err != nil {
+		t.Error("handle: close:", err)
+	}
+
+	if freed != p {
+		t.Error("handle: destructor of a bound function result was not called")
+	}
+}
+
+func TestBindOwnedInvalidReturn(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("ownership of an int return value was accepted")
+		}
+	}()
+
+	Bind[func(int) int](Prepare(Int, Int).WithReturn(Freed), unsafe.Pointer(abs))
+}
+
+func TestHandleArgument(t *testing.T) {
+	var h *Handle
+	Call(unsafe.Pointer(strdup), Return(&h, Freed), "hello")
+
+	n := uintptr(0)
+	Call(unsafe.Pointer(strnlen), &n, h, uintptr(16))
+
+	if n != 5 {
+		t.Error("strnlen: invalid length of handle memory:", n)
+	}
+
+	length := Bind[func(*Handle, uintptr) uintptr](Prepare(SizeT, Pointer, SizeT), unsafe.Pointer(strnlen))
+
+	if n := length(h, 16); n != 5 {
+		t.Error("strnlen: invalid length of handle memory:", n)
+	}
+
+	h.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("passing a closed handle did not panic")
+		}
+	}()
+
+	length(h, 16)
+}
+
+func TestHandleFinalizerLogsLeak(t *testing.T) {
+	released := make(chan unsafe.Pointer, 1)
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		released <- p
+		Free(p)
+	})
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	TrackLeaks = true
+	NewOwned[uintptr](Malloc(8), FreedWith(unsafe.Pointer(free.Pointer())))
+	TrackLeaks = false
+
+	for i := 0; i != 100; i++ {
+		runtime.GC()
+
+		select {
+		case <-released:
+			if s := out.String(); !strings.Contains(s, "was not closed") || !strings.Contains(s, "TestHandleFinalizerLogsLeak") {
+				t.Error("handle: invalid leak log:", s)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	t.Error("handle: destructor was not called by the finalizer")
+}
+
+func TestNewOwnedInvalidSize(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("owned handle of int32 was accepted")
+		}
+	}()
+
+	NewOwned[int32](nil, Borrowed)
+}
+
+func TestCallFrexpOut(t *testing.T) {
+	exp := 0
+	res := 0.0
+	Call(unsafe.Pointer(frexp), &res, 48.0, Out(&exp))
+
+	if res != 0.75 || exp != 6 {
+		t.Error("frexp: invalid results:", res, exp)
+	}
+}
+
+func TestCallStrtolOut(t *testing.T) {
+	var end unsafe.Pointer
+	var res int64
+	Call(unsafe.Pointer(strtol), &res, "1234xyz", Out(&end), 10)
+
+	if res != 1234 {
+		t.Error("strtol: invalid returned value:", res)
+	}
+
+	if s := string(SliceOf[byte](end, 3)); s != "xyz" {
+		t.Error("strtol: invalid end pointer:", s)
+	}
+}
+
+func TestCallClosureInOut(t *testing.T) {
+	double := ClosureOf(func(p unsafe.Pointer) { *(*int32)(p) *= 2 })
+
+	n := 21
+	Call(unsafe.Pointer(double.Pointer()), nil, InOut(&n))
+
+	if n != 42 {
+		t.Error("closure: invalid in-out value:", n)
+	}
+}
+
+func TestCallOutOwnedString(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	alloc := ClosureOf(func(p unsafe.Pointer) {
+		s := Malloc(4)
+		copy(SliceOf[byte](s, 4), "abc\x00")
+		*(*unsafe.Pointer)(p) = s
+	})
+
+	var s string
+	Call(unsafe.Pointer(alloc.Pointer()), nil, OutOwned(&s, FreedWith(unsafe.Pointer(free.Pointer()))))
+
+	if s != "abc" {
+		t.Error("closure: invalid out string:", s)
+	}
+
+	if freed == nil {
+		t.Error("closure: out string was not released")
+	}
+}
+
+func TestCallPosixMemalignOutOwned(t *testing.T) {
+	var freed unsafe.Pointer
+
+	free := ClosureOf(func(p unsafe.Pointer) {
+		freed = p
+		Free(p)
+	})
+
+	var h *Handle
+	var res int32
+	Call(unsafe.Pointer(memalign), &res, OutOwned(&h, FreedWith(unsafe.Pointer(free.Pointer()))), uintptr(64), uintptr(128))
+
+	if res != 0 || h == nil {
+		t.Fatal("posix_memalign: allocation failed:", res)
+	}
+
+	p := h.Get()
+
+	if uintptr(p)%64 != 0 {
+		t.Errorf("posix_memalign: misaligned pointer: %p", p)
+	}
+
+	h.Close()
+
+	if freed != p {
+		t.Error("posix_memalign: destructor was not called")
+	}
+}
+
+func TestInOutInvalidHandle(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("in-out parameter of handle type was accepted")
+		}
+	}()
+
+	var h *Handle
+	InOut(&h)
+}
+
+func TestOutInvalidPointer(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("out parameter of non-pointer type was accepted")
+		}
+	}()
+
+	Out(42)
+}
+
+func TestCallClosureStringArray(t *testing.T) {
+	var got []string
+
+	join := Closure(func(args []string) int {
+		got = args
+		return len(args)
+	})
+
+	for _, args := range [][]string{{"a", "bc", "def"}, {}} {
+		n := 0
+		Call(unsafe.Pointer(join.Pointer()), &n, args)
+
+		if n != len(args) {
+			t.Error("closure: invalid number of strings:", n)
+		}
+
+		if got == nil || strings.Join(got, ",") != strings.Join(args, ",") {
+			t.Error("closure: invalid strings:", got)
+		}
+	}
+}
+
+func TestBindStrnlen(t *testing.T) {
+	length := Bind[func([]byte) uintptr](Prepare(ULong, Pointer, ULong).WithLength(1, 0), unsafe.Pointer(strnlen))
+
+	if n := length([]byte("hello\x00world")); n != 5 {
+		t.Error("strnlen: invalid length:", n)
+	}
+
+	if n := length([]byte("abc")); n != 3 {
+		t.Error("strnlen: invalid length:", n)
+	}
+}
+
+func TestBindClosureWithLength(t *testing.T) {
+	cif := Prepare(Int, Int, Pointer, ULong).WithLength(2, 1)
+
+	sum := cif.Closure(func(base int, values []int32) int {
+		for _, v := range values {
+			base += int(v)
+		}
+		return base
+	})
+
+	call := Bind[func(int, []int32) int](cif, unsafe.Pointer(sum.Pointer()))
+
+	if n := call(1, []int32{10, 20, 30}); n != 61 {
+		t.Error("closure: invalid sum:", n)
+	}
+
+	if n := call(2, nil); n != 2 {
+		t.Error("closure: invalid sum:", n)
+	}
+}
+
+func TestClosureStringArrayWithLength(t *testing.T) {
+	var got []string
+
+	cif := Prepare(Int, Pointer, Int).WithLength(1, 0)
+
+	join := cif.Closure(func(args []string) int {
+		got = args
+		return len(args)
+	})
+
+	// the array is not truncated by a NULL entry after the declared length
+	strs := []string{"a", "b", "c"}
+	arr := SliceOf[unsafe.Pointer](Malloc(4*unsafe.Sizeof(unsafe.Pointer(nil))), 4)
+	defer Free(unsafe.Pointer(&arr[0]))
+
+	for i, s := range strs {
+		arr[i] = Malloc(2)
+		defer Free(arr[i])
+		copy(SliceOf[byte](arr[i], 2), s+"\x00")
+	}
+	arr[3] = nil
+
+	res := 0
+	Call(unsafe.Pointer(join.Pointer()), &res, unsafe.Pointer(&arr[0]), int32(2))
+
+	if res != 2 || len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Error("closure: invalid string array:", res, got)
+	}
+}
+
+func TestClosurePointerParameter(t *testing.T) {
+	swap := Closure(func(p *int32, v int32) int32 {
+		if p == nil {
+			return -1
+		}
+		old := *p
+		*p = v
+		return old
+	})
+
+	res := int32(0)
+	arg := int32(21)
+	Call(unsafe.Pointer(swap.Pointer()), &res, &arg, int32(42))
+
+	if res != 21 || arg != 42 {
+		t.Error("closure: invalid values after swap:", res, arg)
+	}
+
+	Call(unsafe.Pointer(swap.Pointer()), &res, (*int32)(nil), int32(42))
+
+	if res != -1 {
+		t.Error("closure: invalid returned value for NULL:", res)
+	}
+}
+
+func TestClosureSliceParameter(t *testing.T) {
+	cif := Prepare(Int, Pointer, ULong).WithLength(1, 0)
+
+	count := cif.Closure(func(b []byte) int {
+		if b == nil {
+			return -1
+		}
+		return bytes.Count(b, []byte("l"))
+	})
+
+	call := Bind[func([]byte) int](cif, unsafe.Pointer(count.Pointer()))
+
+	if n := call([]byte("hello world")); n != 3 {
+		t.Error("closure: invalid count:", n)
+	}
+
+	if n := call(nil); n != -1 {
+		t.Error("closure: invalid count for NULL:", n)
+	}
+}
+
+func TestClosureSliceParameterWithoutLength(t *testing.T) {
+	for _, create := range []func(){
+		func() { Closure(func(b []byte) int { return len(b) }) },
+		func() { Prepare(Int, Pointer).Closure(func(b []byte) int { return len(b) }) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("creating a closure with a slice parameter without length did not panic")
+				}
+			}()
+			create()
+		}()
+	}
+}
+
+func TestBindInvalidSignature(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("binding a function with mismatching parameters did not panic")
+		}
+	}()
+
+	Bind[func(int, []byte) uintptr](Prepare(ULong, Pointer, ULong).WithLength(1, 0), unsafe.Pointer(strnlen))
+}
+
+func TestStrictCallIntOverflow(t *testing.T) {
+	defer func(strict bool) { Strict = strict }(Strict)
+	Strict = true
+
+	res := 0
+
+	if err := Call(unsafe.Pointer(abs), &res, -3); err != nil || res != 3 {
+		t.Error("strict call: invalid result:", res, err)
+	}
+
+	err := Call(unsafe.Pointer(abs), &res, 1<<40)
+	e, ok := err.(*RangeError)
+
+	if !ok {
+		t.Fatal("strict call: expected a range error but got", err)
+	}
+
+	if e.Index != 0 || e.Value != 1<<40 || !sameType(e.Type, Int) {
+		t.Error("strict call: invalid range error:", e)
+	}
+
+	if s := e.Error(); s != "ffi: argument 0 (1099511627776) overflows int" {
+		t.Error("strict call: invalid error message:", s)
+	}
+}
+
+func TestStrictBindError(t *testing.T) {
+	defer func(strict bool) { Strict = strict }(Strict)
+	Strict = true
+
+	call := Bind[func(int) (int, error)](Prepare(Int, Int), unsafe.Pointer(abs))
+
+	if n, err := call(-1); n != 1 || err != nil {
+		t.Error("strict bind: invalid result:", n, err)
+	}
+
+	if _, err := call(-1 << 40); err == nil {
+		t.Error("strict bind: no error for an argument overflowing int")
+	}
+}
+
+func TestStrictBindPanic(t *testing.T) {
+	defer func(strict bool) { Strict = strict }(Strict)
+	Strict = true
+
+	call := Bind[func(uint) uint](Prepare(UInt, UInt), unsafe.Pointer(abs))
+
+	defer func() {
+		if _, ok := recover().(*RangeError); !ok {
+			t.Error("strict bind: no panic for an argument overflowing unsigned int")
+		}
+	}()
+
+	call(1 << 32)
+}
+
+func TestStrictRanges(t *testing.T) {
+	for _, test := range []struct {
+		value interface{}
+		typ   Type
+		ok    bool
+	}{
+		{int64(-128), Int8, true},
+		{int64(-129), Int8, false},
+		{uint64(255), UInt8, true},
+		{int8(-1), UInt8, false},
+		{uint64(1 << 63), Int64, false},
+		{uint64(1 << 63), UInt64, true},
+		{3.5e38, Float, false},
+		{math.Inf(1), Float, true},
+		{"hello", Pointer, true},
+	} {
+		if ok := inRange(reflect.ValueOf(test.value), test.typ); ok != test.ok {
+			t.Errorf("%v in range of %s: %t != %t", test.value, test.typ, ok, test.ok)
+		}
+	}
+}
+
+func TestPointerToTypeString(t *testing.T) {
+	testTypeString(t, PointerTo(Char), "char *")
+	testTypeString(t, PointerTo(PointerTo(Char)), "char **")
+
+	if s := Prepare(SizeT, PointerTo(Char), SizeT).String(); s != "size_t(*)(char *, size_t)" {
+		t.Error("invalid interface string:", s)
+	}
+
+	if elem, ok := PointerTo(Int).Elem(); !ok || elem != Int {
+		t.Error("invalid pointed type:", elem)
+	}
+
+	if _, ok := Pointer.Elem(); ok {
+		t.Error("void * has a pointed type")
+	}
+}
+
+func TestPointerToFormatValue(t *testing.T) {
+	n := int32(42)
+	var h *Handle
+	Call(unsafe.Pointer(strdup), Return(&h, Freed), "x")
+	h.Close()
+
+	for _, test := range []struct {
+		typ   Type
+		value interface{}
+		str   string
+	}{
+		{PointerTo(Int), &n, "&42"},
+		{PointerTo(Int), (*int32)(nil), "NULL"},
+		{PointerTo(Int), nil, "NULL"},
+		{PointerTo(Int32), []int32{1, 2}, "[1 2]"},
+		{PointerTo(Char), "hello", `"hello"`},
+		{PointerTo(Char), []byte("abc"), `"abc"`},
+		{PointerTo(PointerTo(Char)), []string{"a", "b"}, `["a" "b"]`},
+		{Pointer, "hello", `"hello"`},
+		{Pointer, unsafe.Pointer(nil), "NULL"},
+		{Pointer, h, "<closed>"},
+		{Int, 42, "42"},
+	} {
+		if s := test.typ.FormatValue(test.value); s != test.str {
+			t.Errorf("invalid formatted value of %s: %s != %s", test.typ, s, test.str)
+		}
+	}
+}
+
+func TestFormatCall(t *testing.T) {
+	n := int32(21)
+	cif := Prepare(SizeT, PointerTo(Char), SizeT)
+
+	if s := cif.FormatCall("strnlen", uintptr(5), "hello", uintptr(16)); s != `strnlen("hello", 16) = 5` {
+		t.Error("invalid formatted call:", s)
+	}
+
+	if s := Prepare(Void, PointerTo(Int)).FormatCall("double", nil, &n); s != "double(&21)" {
+		t.Error("invalid formatted call:", s)
+	}
+}
+
+func TestStrictBindPointerType(t *testing.T) {
+	defer func(strict bool) { Strict = strict }(Strict)
+	Strict = true
+
+	cif := Prepare(SizeT, PointerTo(Char), SizeT).WithLength(1, 0)
+
+	length := Bind[func([]byte) (uintptr, error)](cif, unsafe.Pointer(strnlen))
+
+	if n, err := length([]byte("hello\x00world")); n != 5 || err != nil {
+		t.Error("strnlen: invalid result:", n, err)
+	}
+
+	words := Bind[func([]int64) (uintptr, error)](cif, unsafe.Pointer(strnlen))
+
+	if _, err := words([]int64{1}); err == nil {
+		t.Error("strnlen: no error for a slice of int64 passed as char *")
+	} else if s := err.Error(); s != "ffi: argument 0 ([]int64) does not match char *" {
+		t.Error("strnlen: invalid error message:", s)
+	}
+}
+
+func TestClosurePointerToDeref(t *testing.T) {
+	double := Prepare(Int, PointerTo(Int)).Closure(func(n int) int { return 2 * n })
+
+	res := 0
+	arg := int32(21)
+	Call(unsafe.Pointer(double.Pointer()), &res, &arg)
+
+	if res != 42 {
+		t.Error("closure: invalid returned value:", res)
+	}
+
+	Call(unsafe.Pointer(double.Pointer()), &res, nil)
+
+	if res != 0 {
+		t.Error("closure: invalid returned value for NULL:", res)
+	}
+}
+
+type color int32
+
+var colorType = EnumOf(Int, "enum color", map[string]int64{
+	"COLOR_RED":   0,
+	"COLOR_GREEN": 1,
+	"COLOR_BLUE":  2,
+	"COLOR_FIRST": 0,
+})
+
+func TestEnumFormat(t *testing.T) {
+	if s := Prepare(colorType, PointerTo(colorType)).String(); s != "enum color(*)(enum color *)" {
+		t.Error("invalid interface string:", s)
+	}
+
+	for _, test := range []struct {
+		value interface{}
+		str   string
+	}{
+		{color(0), "COLOR_FIRST"},
+		{color(2), "COLOR_BLUE"},
+		{uint8(1), "COLOR_GREEN"},
+		{color(42), "42"},
+		{"red", "red"},
+	} {
+		if s := colorType.FormatValue(test.value); s != test.str {
+			t.Errorf("invalid formatted value of %v: %s != %s", test.value, s, test.str)
+		}
+	}
+
+	if v, ok := colorType.EnumValue("COLOR_BLUE"); !ok || v != 2 {
+		t.Error("invalid enumerator value:", v, ok)
+	}
+}
+
+func TestEnumOfInvalidBase(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("creating an enum with a floating point base type did not panic")
+		}
+	}()
+
+	EnumOf(Double, "enum invalid", nil)
+}
+
+func TestEnumFormatCall(t *testing.T) {
+	cif := Prepare(PointerTo(Char), colorType, PointerTo(colorType))
+	c := color(1)
+
+	if s := cif.FormatCall("color_name", "blue", color(2), &c); s != `color_name(COLOR_BLUE, &COLOR_GREEN) = "blue"` {
+		t.Error("invalid formatted call:", s)
+	}
+}
+
+func TestEnumClosure(t *testing.T) {
+	cif := Prepare(colorType, colorType)
+
+	next := cif.Closure(func(c color) color { return (c + 1) % 3 })
+	call := Bind[func(color) (color, error)](cif, unsafe.Pointer(next.Pointer()))
+
+	if c, err := call(2); c != 0 || err != nil {
+		t.Error("closure: invalid returned value:", c, err)
+	}
+
+	defer func(strict bool) { Strict = strict }(Strict)
+	Strict = true
+
+	if _, err := call(3); err == nil {
+		t.Error("closure: no error for a value which is not part of the enum")
+	} else if s := err.Error(); s != "ffi: argument 0 (3) is not a value of enum color (COLOR_FIRST, COLOR_GREEN, COLOR_BLUE)" {
+		t.Error("closure: invalid error message:", s)
+	}
+}
+
+func TestNullStringPointerArgument(t *testing.T) {
+	length := Closure(func(s *string) int {
+		if s == nil {
+			return -1
+		}
+		return len(*s)
+	})
+
+	str := "hello"
+
+	for _, test := range []struct {
+		arg interface{}
+		res int
+	}{
+		{(*string)(nil), -1},
+		{nil, -1},
+		{&str, 5},
+	} {
+		res := 0
+		Call(unsafe.Pointer(length.Pointer()), &res, test.arg)
+
+		if res != test.res {
+			t.Errorf("closure: invalid result for %#v: %d", test.arg, res)
+		}
+	}
+}
+
+func TestNullSliceArgument(t *testing.T) {
+	isNull := ClosureOf(func(p unsafe.Pointer) int {
+		if p == nil {
+			return 1
+		}
+		return 0
+	})
+
+	for _, test := range []struct {
+		arg interface{}
+		res int
+	}{
+		{[]byte(nil), 1},
+		{[]byte{}, 0},
+		{[]int32{1}, 0},
+		{[]string(nil), 1},
+		{[]string{}, 0},
+	} {
+		res := 0
+		Call(unsafe.Pointer(isNull.Pointer()), &res, test.arg)
+
+		if res != test.res {
+			t.Errorf("closure: invalid result for %#v: %d", test.arg, res)
+		}
+	}
+}
+
+func TestNullStringsClosureArgument(t *testing.T) {
+	var got []string
+
+	count := Closure(func(args []string) int {
+		got = args
+		return len(args)
+	})
+
+	Call(unsafe.Pointer(count.Pointer()), nil, []string(nil))
+
+	if got != nil {
+		t.Error("closure: expected nil slice for NULL but got", got)
+	}
+}
+
+func TestNullStringPointerReturn(t *testing.T) {
+	var ret *string
+
+	get := Closure(func(null int) *string {
+		if null != 0 {
+			return nil
+		}
+		s := "hello"
+		return &s
+	})
+
+	Call(unsafe.Pointer(get.Pointer()), &ret, 1)
+
+	if ret != nil {
+		t.Error("closure: expected nil for NULL but got", *ret)
+	}
+
+	Call(unsafe.Pointer(get.Pointer()), Return(&ret, Freed), 0)
+
+	if ret == nil || *ret != "hello" {
+		t.Error("closure: invalid returned string:", ret)
+	}
+
+	var str string
+	Call(unsafe.Pointer(get.Pointer()), &str, 1)
+
+	if str != "" {
+		t.Error("closure: expected empty string for NULL but got", str)
+	}
+}
+
+func TestCallWcslen(t *testing.T) {
+	var n uintptr
+	Call(unsafe.Pointer(wcslen), &n, Encoded("héllo €", WChar))
+
+	if n != 7 {
+		t.Error("wcslen: invalid length:", n)
+	}
+}
+
+func TestCallWcschrReturn(t *testing.T) {
+	var s string
+	Call(unsafe.Pointer(wcschr), Encoded(&s, WChar), Encoded("héllo", WChar), int32('l'))
+
+	if s != "llo" {
+		t.Error("wcschr: invalid returned string:", s)
+	}
+}
+
+func TestEncodedArgumentLayout(t *testing.T) {
+	var utf16, latin1 []byte
+
+	read := Closure(func(p unsafe.Pointer, q unsafe.Pointer) {
+		utf16 = append([]byte{}, SliceOf[byte](p, 6)...)
+		latin1 = append([]byte{}, SliceOf[byte](q, 6)...)
+	})
+
+	Call(unsafe.Pointer(read.Pointer()), nil, Encoded("hé", UTF16), Encoded("café€", Latin1))
+
+	if !bytes.Equal(utf16, []byte{'h', 0, 0xe9, 0, 0, 0}) {
+		t.Errorf("invalid UTF-16 string: %x", utf16)
+	}
+
+	if !bytes.Equal(latin1, []byte{'c', 'a', 'f', 0xe9, '?', 0}) {
+		t.Errorf("invalid Latin-1 string: %x", latin1)
+	}
+}
+
+func TestBindClosureUTF16(t *testing.T) {
+	cif := Prepare(Pointer, Pointer).WithEncoding(UTF16)
+	upper := cif.Closure(func(s string) string { return strings.ToUpper(s) })
+	call := Bind[func(string) string](cif, unsafe.Pointer(upper.Pointer()))
+
+	if s := call("héllo 😀"); s != "HÉLLO 😀" {
+		t.Error("closure: invalid returned string:", s)
+	}
+}
+
+func TestGoStringN(t *testing.T) {
+	type record struct {
+		Name [8]byte
+		Code [3]byte
+		Wide [4]uint16
+	}
+
+	r := record{
+		Name: [8]byte{'a', 'b', 0, 'x'},
+		Code: [3]byte{'a', 'b', 'c'},
+		Wide: [4]uint16{'h', 0xe9, 0, 'x'},
+	}
+
+	if s := GoStringN(unsafe.Pointer(&r.Name), len(r.Name), UTF8); s != "ab" {
+		t.Errorf("invalid string: %q", s)
+	}
+
+	if s := GoStringN(unsafe.Pointer(&r.Code), len(r.Code), UTF8); s != "abc" {
+		t.Errorf("invalid string: %q", s)
+	}
+
+	if s := GoStringN(unsafe.Pointer(&r.Wide), int(unsafe.Sizeof(r.Wide)), UTF16); s != "hé" {
+		t.Errorf("invalid string: %q", s)
+	}
+}
+
+type temperature struct {
+	celsius float64
+}
+
+func (t temperature) FFIType() Type {
+	return Double
+}
+
+func (t temperature) MarshalFFI(p unsafe.Pointer) {
+	*(*float64)(p) = t.celsius
+}
+
+func (t *temperature) UnmarshalFFI(p unsafe.Pointer) {
+	t.celsius = *(*float64)(p)
+}
+
+func TestCallMarshaler(t *testing.T) {
+	var ret temperature
+
+	if err := Call(unsafe.Pointer(fabs), &ret, temperature{-21.5}); err != nil {
+		t.Error("fabs:", err)
+	}
+
+	if ret.celsius != 21.5 {
+		t.Error("fabs: invalid returned value:", ret)
+	}
+}
+
+func TestClosureMarshaler(t *testing.T) {
+	double := Closure(func(t temperature) temperature {
+		return temperature{2 * t.celsius}
+	})
+
+	var ret temperature
+	Call(unsafe.Pointer(double.Pointer()), &ret, temperature{21})
+
+	if ret.celsius != 42 {
+		t.Error("closure: invalid returned value:", ret)
+	}
+
+	res := 0.0
+	Call(unsafe.Pointer(double.Pointer()), &res, 1.5)
+
+	if res != 3 {
+		t.Error("closure: invalid returned value:", res)
+	}
+}
+
+func TestCallBoolClosure(t *testing.T) {
+	not := Closure(func(b bool) bool { return !b })
+
+	for _, b := range []bool{false, true} {
+		ret := b
+		Call(unsafe.Pointer(not.Pointer()), &ret, b)
+
+		if ret == b {
+			t.Error("closure: invalid returned value:", ret)
+		}
+	}
+}
+
+func TestCallUintClosure(t *testing.T) {
+	half := Closure(func(n uint) uint { return n / 2 })
+
+	var ret uint
+	Call(unsafe.Pointer(half.Pointer()), &ret, uint(42))
+
+	if ret != 21 {
+		t.Error("closure: invalid returned value:", ret)
+	}
+}
+
+func TestUintptrIsSizeT(t *testing.T) {
+	cif := Closure(func(p uintptr, n uintptr) uintptr { return n }).(*function).Interface
+
+	if s := cif.String(); s != "size_t(*)(size_t, size_t)" {
+		t.Error("closure: invalid interface:", s)
+	}
+}
+
+func TestCallComplexFloat(t *testing.T) {
+	var ret float32
+
+	if err := Call(unsafe.Pointer(cabsf), &ret, complex64(3+4i)); err != nil {
+		t.Error("cabsf:", err)
+	}
+
+	if ret != 5 {
+		t.Error("cabsf: invalid returned value:", ret)
+	}
+}
+
+func TestCallComplexFloatClosure(t *testing.T) {
+	conj := Closure(func(c complex64) complex64 { return complex(real(c), -imag(c)) })
+
+	var ret complex64
+	Call(unsafe.Pointer(conj.Pointer()), &ret, complex64(1+2i))
+
+	if ret != 1-2i {
+		t.Error("closure: invalid returned value:", ret)
+	}
+}
+
+func TestCallComplexDouble(t *testing.T) {
+	skipUnlessSupported(t, ComplexDouble)
+
+	var ret float64
+
+	if err := Call(unsafe.Pointer(cabs), &ret, complex128(3+4i)); err != nil {
+		t.Error("cabs:", err)
+	}
+
+	if ret != 5 {
+		t.Error("cabs: invalid returned value:", ret)
+	}
+
+	conj := Closure(func(c complex128) complex128 { return complex(real(c), -imag(c)) })
+
+	var res complex128
+	Call(unsafe.Pointer(conj.Pointer()), &res, complex128(1+2i))
+
+	if res != 1-2i {
+		t.Error("closure: invalid returned value:", res)
+	}
+}
+
+func TestTypedCallRejectsWideValues(t *testing.T) {
+	skipUnlessSupported(t, ComplexDouble)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("frame: no panic for a double _Complex argument")
+		}
+	}()
+
+	Prepare(Double, ComplexDouble).NewFrame()
+}
+
+func TestLongDoubleFloat64(t *testing.T) {
+	for _, f := range []float64{0, 1, -1.5, math.Pi, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(-1)} {
+		if x := LongDoubleOf(f).Float64(); x != f {
+			t.Error("long double: invalid conversion of", f, "to float64:", x)
+		}
+	}
+
+	if x := LongDoubleOf(math.NaN()); x.Big() != nil || x.String() != "NaN" {
+		t.Error("long double: invalid conversion of NaN:", x)
+	}
+}
+
+func TestLongDoubleBig(t *testing.T) {
+	for _, s := range []string{"0", "-0", "1", "-2.5", "1.1", "3.14159265358979323846", "0x1p-16440", "1e4930", "-Inf"} {
+		f, _, err := big.ParseFloat(s, 0, LongDoubleOf(1).Big().Prec(), big.ToNearestEven)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if x := LongDoubleOfBig(f).Big(); x.Cmp(f) != 0 || x.Signbit() != f.Signbit() {
+			t.Error("long double: invalid conversion of", s, "to big.Float:", x)
+		}
+	}
+}
+
+func TestCallStrtold(t *testing.T) {
+	skipUnlessSupported(t, LongDoubleType)
+
+	var ret LongDouble
+
+	if err := Call(unsafe.Pointer(strtold), &ret, "1.1", nil); err != nil {
+		t.Error("strtold:", err)
+	}
+
+	f, _, _ := big.ParseFloat("1.1", 10, ret.Big().Prec(), big.ToNearestEven)
+
+	if ret.Big().Cmp(f) != 0 {
+		t.Error("strtold: invalid returned value:", ret)
+	}
+}
+
+func TestCallExpl(t *testing.T) {
+	skipUnlessSupported(t, LongDoubleType)
+
+	var ret LongDouble
+
+	if err := Call(unsafe.Pointer(expl), &ret, LongDoubleOf(1)); err != nil {
+		t.Error("expl:", err)
+	}
+
+	if f := ret.Float64(); f != math.E {
+		t.Error("expl: invalid returned value:", ret)
+	}
+}
+
+func TestLongDoubleClosure(t *testing.T) {
+	skipUnlessSupported(t, LongDoubleType)
+
+	half := Closure(func(x LongDouble) LongDouble {
+		f := x.Big()
+		return LongDoubleOfBig(f.Quo(f, big.NewFloat(2)))
+	})
+
+	var ret LongDouble
+	Call(unsafe.Pointer(half.Pointer()), &ret, LongDoubleOf(3))
+
+	if f := ret.Float64(); f != 1.5 {
+		t.Error("closure: invalid returned value:", ret)
+	}
+}
+
+func TestRecordLayout(t *testing.T) {
+	point := StructOf("struct point", Field{"x", Int16}, Field{"y", Double})
+	shape := StructOf("struct shape",
+		Field{"name", ArrayOf(Char, 6)},
+		Field{"origin", point},
+		Field{"corners", ArrayOf(point, 4)},
+		Field{"visible", Bool},
+	)
+
+	if n := point.Size(); n != 16 {
+		t.Error("invalid size of struct point:", n)
+	}
+
+	if n := shape.Size(); n != 96 {
+		t.Error("invalid size of struct shape:", n)
+	}
+
+	r := NewRecord(shape)
+	copy(SliceOf[byte](r.Get("name").(*Record).Pointer(), 6), "square")
+
+	r.Get("origin").(*Record).Set("x", 1)
+	r.Get("origin").(*Record).Set("y", 2)
+	r.Get("corners").(*Record).Index(2).(*Record).Set("y", 0.5)
+	r.Set("visible", true)
+
+	corner := NewRecord(point)
+	corner.Set("x", int64(-3))
+	r.Get("corners").(*Record).SetIndex(3, corner)
+
+	if s := GoStringN(r.Get("name").(*Record).Pointer(), 6, UTF8); s != "square" {
+		t.Error("invalid name:", s)
+	}
+
+	if x, y := r.Get("origin").(*Record).Get("x"), r.Get("origin").(*Record).Get("y"); x != int16(1) || y != 2.0 {
+		t.Error("invalid origin:", x, y)
+	}
+
+	corners := r.Get("corners").(*Record)
+
+	if y := corners.Index(2).(*Record).Get("y"); y != 0.5 {
+		t.Error("invalid corner:", y)
+	}
+
+	if x := corners.Index(3).(*Record).Get("x"); x != int16(-3) {
+		t.Error("invalid corner:", x)
+	}
+
+	if v := r.Get("visible"); v != true {
+		t.Error("invalid visibility:", v)
+	}
+
+	if n := corners.Len(); n != 4 {
+		t.Error("invalid number of corners:", n)
+	}
+
+	if off := uintptr(corners.Pointer()) - uintptr(r.Pointer()); off != 24 {
+		t.Error("invalid offset of corners:", off)
+	}
+}
+
+func TestRecordInvalidField(t *testing.T) {
+	r := NewRecord(StructOf("struct point", Field{"x", Int}, Field{"y", Int}))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("accessing a missing field did not panic")
+		}
+	}()
+
+	r.Set("z", 1)
+}
+
+func TestRecordSetMismatchingStruct(t *testing.T) {
+	pair := StructOf("struct pair", Field{"a", Int32}, Field{"b", Int32})
+	wide := StructOf("struct wide", Field{"n", Int64})
+	r := NewRecord(StructOf("struct outer", Field{"pair", pair}, Field{"next", PointerTo(pair)}))
+
+	r.Set("next", r)
+
+	if p := r.Get("next"); p != r.Pointer() {
+		t.Error("invalid pointer field:", p)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("assigning a record of a different struct type of the same size did not panic")
+		}
+	}()
+
+	r.Set("pair", NewRecord(wide))
+}
+
+func TestCallRecordByValue(t *testing.T) {
+	divType := StructOf("div_t", Field{"quot", Int}, Field{"rem", Int})
+	skipUnlessSupported(t, divType)
+
+	ret := NewRecord(divType)
+
+	if err := Call(unsafe.Pointer(div), ret, 7, 2); err != nil {
+		t.Error("div:", err)
+	}
+
+	if q, r := ret.Get("quot"), ret.Get("rem"); q != int32(3) || r != int32(1) {
+		t.Error("div: invalid result:", q, r)
+	}
+
+	cif := Prepare(divType, Int, Int)
+	res := NewRecord(divType)
+	num, den := int32(-9), int32(4)
+	cif.Call(unsafe.Pointer(div), res.Pointer(), unsafe.Pointer(&num), unsafe.Pointer(&den))
+
+	if q, r := res.Get("quot"), res.Get("rem"); q != int32(-2) || r != int32(-1) {
+		t.Error("div: invalid result:", q, r)
+	}
+}
+
+func TestCallRecordByPointer(t *testing.T) {
+	tm := StructOf("struct tm",
+		Field{"tm_sec", Int},
+		Field{"tm_min", Int},
+		Field{"tm_hour", Int},
+		Field{"tm_mday", Int},
+		Field{"tm_mon", Int},
+		Field{"tm_year", Int},
+		Field{"tm_wday", Int},
+		Field{"tm_yday", Int},
+		Field{"tm_isdst", Int},
+		Field{"tm_gmtoff", Long},
+		Field{"tm_zone", PointerTo(Char)},
+	)
+
+	r := NewRecord(tm)
+	r.Set("tm_year", 2024-1900)
+	r.Set("tm_mon", 1)
+	r.Set("tm_mday", 29)
+	r.Set("tm_hour", 12)
+
+	var ret int64
+	cif := Prepare(Long, PointerTo(tm))
+	ptr := r.Pointer()
+	cif.Call(unsafe.Pointer(timegm), unsafe.Pointer(&ret), unsafe.Pointer(&ptr))
+
+	if want := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC).Unix(); ret != want {
+		t.Error("timegm: invalid time:", ret, "!=", want)
+	}
+
+	if s := cif.String(); s != "long(*)(struct tm *)" {
+		t.Error("invalid interface string:", s)
+	}
+}
+
+// skipUnlessSupported skips tests using types which the backend does not
+// support.
+func skipUnlessSupported(t *testing.T, typ Type) {
+	defer func() {
+		if recover() != nil {
+			t.Skip("type not supported by the backend:", typ)
+		}
+	}()
+	Prepare(typ)
+}
+
+func init() {
+	var err error
+
+	if libc, err = load("libc"); err != nil {
+		panic(err)
+	}
+
+	if libm, err = load("libm"); err != nil {
+		panic(err)
+	}
+
+	abs = symbol(libc, "abs")
+	cabs = symbol(libm, "cabs")
+	cabsf = symbol(libm, "cabsf")
+	div = symbol(libc, "div")
+	expl = symbol(libm, "expl")
+	fabs = symbol(libm, "fabs")
+	fabsf = symbol(libm, "fabsf")
+	fmax = symbol(libm, "fmax")
+	frexp = symbol(libm, "frexp")
+	memalign = symbol(libc, "posix_memalign")
+	qsort = symbol(libc, "qsort")
+	snprintf = symbol(libc, "snprintf")
+	strdup = symbol(libc, "strdup")
+	strerror = symbol(libc, "strerror")
+	strnlen = symbol(libc, "strnlen")
+	strtol = symbol(libc, "strtol")
+	strtold = symbol(libc, "strtold")
+	timegm = symbol(libc, "timegm")
+	wcschr = symbol(libc, "wcschr")
+	wcslen = symbol(libc, "wcslen")
+}
+
+func load(name string) (lib dl.Library, err error) {
+	var path string
+
+	if path, err = dl.Find(name); err != nil {
+		return
+	}
+
+	if lib, err = dl.Open(path, 0); err != nil {
+		return
+	}
+
+	return
+}
+
+func symbol(lib dl.Library, name string) (addr uintptr) {
+	var err error
+
+	if addr, err = lib.Symbol(name); err != nil {
+		panic(err)
+	}
+
+	return
+}
+
+func TestArenaAlignment(t *testing.T) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	var ret float64
+	vret := reflect.ValueOf(&ret)
+	varg := []reflect.Value{
+		reflect.ValueOf("hello"),
+		reflect.ValueOf([]string{"a", "b"}),
+		reflect.ValueOf(int8(1)),
+	}
+
+	mem := acquireArena(arenaSize(vret, varg), &pinner)
+	defer releaseArena(mem)
+
+	retv := makeRetValue(mem, vret)
+	argv := makeArgValues(mem, varg)
+
+	for i, p := range append([]unsafe.Pointer{retv}, argv...) {
+		if uintptr(p)%arenaAlign != 0 {
+			t.Errorf("value %d at %p is not aligned on %d bytes", i, p, arenaAlign)
+		}
+	}
+}
+
+func BenchmarkCallingAbsViaCgo(b *testing.B) {
+	for i, n := 0, b.N; i != n; i++ {
+		ffi_test_abs__(-i)
+	}
+}
+
+func BenchmarkCallingAbsViaInterface(b *testing.B) {
+	cif := Prepare(Int, Int)
+
+	for i, n := 0, b.N; i != n; i++ {
+		arg := -i
+		res := 0
+		cif.Call(unsafe.Pointer(abs), unsafe.Pointer(&res), unsafe.Pointer(&arg))
+	}
+}
+
+func BenchmarkCallingAbsViaCall(b *testing.B) {
+	for i, n := 0, b.N; i != n; i++ {
+		arg := -1
+		res := 0
+		Call(unsafe.Pointer(abs), &res, arg)
+	}
+}
+
+func BenchmarkCallingAbsViaCall1(b *testing.B) {
+	cif := Prepare(Int, Int)
+
+	for i, n := 0, b.N; i != n; i++ {
+		Call1[int32](&cif, unsafe.Pointer(abs), int32(-i))
+	}
+}
+
+func BenchmarkCallingAbsViaFrame(b *testing.B) {
+	frame := Prepare(Int, Int).NewFrame()
+
+	for i, n := 0, b.N; i != n; i++ {
+		frame.SetInt(0, int64(-i))
+		frame.Call(unsafe.Pointer(abs))
+	}
+}
+
+func BenchmarkCallingAbsViaBatch(b *testing.B) {
+	const size = 100
+
+	cif := Prepare(Int, Int)
+	arg := make([]int, size)
+	res := make([]int, size)
+	batch := &Batch{}
+
+	for i := range arg {
+		arg[i] = -i
+		batch.Add(cif, unsafe.Pointer(abs), unsafe.Pointer(&res[i]), unsafe.Pointer(&arg[i]))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i += size {
+		batch.Run()
+	}
+}
+
+func BenchmarkCallingAbsViaMap(b *testing.B) {
+	in := make([]int, b.N)
+	out := make([]int, b.N)
+
+	for i := range in {
+		in[i] = -i
+	}
+
+	b.ResetTimer()
+	Map(Prepare(Int, Int), unsafe.Pointer(abs), out, in)
+}
+
+func BenchmarkCallingAbsViaClosure(b *testing.B) {
+	abs := Closure(func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	})
+
+	for i, n := 0, b.N; i != n; i++ {
+		arg := -i
+		res := 0
+		abs.Call(unsafe.Pointer(&res), unsafe.Pointer(&arg))
+	}
+}
+
+func BenchmarkCallingAbsViaClosureOf(b *testing.B) {
+	abs := ClosureOf(func(n int32) int32 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	})
+
+	cif := Prepare(Int32, Int32)
+	ptr := unsafe.Pointer(abs.Pointer())
+
+	for i, n := 0, b.N; i != n; i++ {
+		Call1[int32](&cif, ptr, int32(-i))
 	}
 }
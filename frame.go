@@ -0,0 +1,75 @@
+package ffi
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// Frame holds argument and return slots for repeated calls through the same
+// Interface. Arguments keep their value between calls so only the ones that
+// change need to be set again.
+//
+// Pointers stored in a frame are not visible to the garbage collector, they
+// must reference C memory or pinned Go memory.
+type Frame struct {
+	cif  Interface
+	ret  *[2]uint64
+	args []uint64
+	mem  unsafe.Pointer
+}
+
+func (cif Interface) NewFrame() *Frame {
+	cif.checkBits()
+	f := &Frame{cif: cif}
+	f.alloc()
+	runtime.SetFinalizer(f, destroyFrame)
+	return f
+}
+
+func (f *Frame) Call(fptr unsafe.Pointer) {
+	f.call(fptr)
+}
+
+func (f *Frame) SetInt(i int, v int64) {
+	f.args[i] = uint64(v)
+}
+
+func (f *Frame) SetUint(i int, v uint64) {
+	f.args[i] = v
+}
+
+func (f *Frame) SetFloat(i int, v float32) {
+	f.args[i] = 0
+	*(*float32)(unsafe.Pointer(&f.args[i])) = v
+}
+
+func (f *Frame) SetDouble(i int, v float64) {
+	*(*float64)(unsafe.Pointer(&f.args[i])) = v
+}
+
+func (f *Frame) SetPointer(i int, v unsafe.Pointer) {
+	*(*unsafe.Pointer)(unsafe.Pointer(&f.args[i])) = v
+}
+
+// The return value getters rely on the backend widening integer results
+// smaller than a register to the full size of the return slot.
+
+func (f *Frame) Int() int64 {
+	return int64(f.ret[0])
+}
+
+func (f *Frame) Uint() uint64 {
+	return f.ret[0]
+}
+
+func (f *Frame) Float() float32 {
+	return *(*float32)(unsafe.Pointer(&f.ret[0]))
+}
+
+func (f *Frame) Double() float64 {
+	return *(*float64)(unsafe.Pointer(&f.ret[0]))
+}
+
+func (f *Frame) Pointer() unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&f.ret[0]))
+}
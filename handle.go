@@ -0,0 +1,124 @@
+package ffi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ErrClosed is returned when closing a handle that was already closed.
+var ErrClosed = errors.New("ffi: handle already closed")
+
+// TrackLeaks enables recording the stack where handles are created, so that
+// handles released by the garbage collector instead of an explicit call to
+// Close are logged along with the place they come from.
+var TrackLeaks bool
+
+// Owned is a C pointer of type T owned by Go, like the opaque handles returned
+// by fopen or sqlite3_open, which is released according to its Ownership when
+// Close is called or when it is garbage collected.
+//
+// Handles given as arguments to Call or to bound functions are passed as the
+// pointer they own, passing a closed handle panics.
+//
+// T must have the size of a pointer, for example unsafe.Pointer, uintptr or a
+// pointer to a cgo type.
+type Owned[T any] struct {
+	ptr   unsafe.Pointer
+	own   Ownership
+	stack []uintptr
+}
+
+// Handle is an owned C pointer of unknown type, it is the type of handles that
+// Call returns when given a **Handle, and that bound functions return with the
+// ownership declared by Interface.WithReturn.
+type Handle = Owned[unsafe.Pointer]
+
+// NewOwned returns a handle owning ptr, the pointer is released by own when
+// the handle is closed.
+func NewOwned[T any](ptr unsafe.Pointer, own Ownership) *Owned[T] {
+	var zero T
+
+	if unsafe.Sizeof(zero) != unsafe.Sizeof(ptr) {
+		panic(fmt.Sprintf("ffi: owned handles must have the size of a pointer but %T has %d bytes", zero, unsafe.Sizeof(zero)))
+	}
+
+	h := &Owned[T]{ptr: ptr, own: own}
+
+	if TrackLeaks {
+		h.stack = make([]uintptr, 32)
+		h.stack = h.stack[:runtime.Callers(2, h.stack)]
+	}
+
+	runtime.SetFinalizer(h, (*Owned[T]).finalize)
+	return h
+}
+
+// Get returns the pointer owned by h, it panics if h was closed.
+func (h *Owned[T]) Get() T {
+	p := h.Pointer()
+	return *(*T)(unsafe.Pointer(&p))
+}
+
+// Pointer returns the pointer owned by h, it panics if h was closed.
+func (h *Owned[T]) Pointer() unsafe.Pointer {
+	p := atomic.LoadPointer(&h.ptr)
+
+	if p == nil {
+		panic("ffi: use of closed handle")
+	}
+
+	return p
+}
+
+// cPointer passes handles given as arguments to C functions as the pointer
+// they own, it panics if h was closed.
+func (h *Owned[T]) cPointer() unsafe.Pointer {
+	return h.Pointer()
+}
+
+// Close releases the pointer owned by h, it returns ErrClosed if h was already
+// closed.
+func (h *Owned[T]) Close() error {
+	p := atomic.SwapPointer(&h.ptr, nil)
+
+	if p == nil {
+		return ErrClosed
+	}
+
+	runtime.SetFinalizer(h, nil)
+	h.own.release(p)
+	return nil
+}
+
+func (h *Owned[T]) finalize() {
+	if h.ptr == nil {
+		return
+	}
+
+	if h.stack != nil {
+		log.Printf("ffi: handle %p of type %T was not closed, created at:\n%s", h.ptr, *new(T), formatStack(h.stack))
+	}
+
+	h.own.release(h.ptr)
+}
+
+func formatStack(stack []uintptr) string {
+	var s strings.Builder
+	frames := runtime.CallersFrames(stack)
+
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&s, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+
+		if !more {
+			break
+		}
+	}
+
+	return s.String()
+}
@@ -0,0 +1,6 @@
+// Package sysv calls C functions and creates C-callable closures following the
+// System V AMD64 calling convention, without depending on libffi.
+//
+// The package only deals with registers and stack slots, deciding which value
+// goes where is left to the caller.
+package sysv
@@ -0,0 +1,123 @@
+//go:build linux && amd64
+
+package sysv
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+)
+
+// MaxStack is the maximum number of 8 bytes words that can be passed on the
+// stack to a function called through a Frame.
+const MaxStack = 32
+
+// MaxArgs is the maximum number of arguments of functions called through a
+// Frame and of closures.
+const MaxArgs = 6 + 8 + MaxStack
+
+// Frame holds the state of the registers and stack before and after calling the
+// C function at Fn.
+//
+// Frames passed to Call or CallBatch must not live on a goroutine stack, C may
+// call back into Go during the call, which can move the stack.
+type Frame struct {
+	Fn     uintptr
+	Ints   [6]uint64
+	SSE    [8]uint64
+	NSSE   uint64
+	NStack uint64
+	Stack  [MaxStack]uint64
+	RAX    uint64
+	RDX    uint64
+	XMM0   uint64
+	XMM1   uint64
+}
+
+// Regs holds the argument registers saved on entry of a closure, and the
+// return registers restored when the closure returns. Regs are allocated on the
+// C stack, Args is scratch space for the callback to build the list of argument
+// addresses without allocating Go memory.
+type Regs struct {
+	Ctx   uintptr
+	Stack unsafe.Pointer
+	Ints  [6]uint64
+	SSE   [8]uint64
+	RAX   uint64
+	XMM0  uint64
+	Args  [MaxArgs]unsafe.Pointer
+}
+
+type batch struct {
+	frames *Frame
+	n      uint64
+}
+
+//go:linkname runtime_cgocall runtime.cgocall
+func runtime_cgocall(fn uintptr, arg unsafe.Pointer) int32
+
+// Addresses of the assembly functions, called with the C ABI.
+var (
+	callABI0      uintptr
+	callBatchABI0 uintptr
+	closureABI0   uintptr
+)
+
+// callback is the address of the C function that closures call with a pointer
+// to their Regs.
+var callback uintptr
+
+func Call(f *Frame) {
+	runtime_cgocall(callABI0, unsafe.Pointer(f))
+}
+
+// CallBatch calls all frames in order within a single transition to C.
+func CallBatch(frames []Frame) {
+	if len(frames) != 0 {
+		runtime_cgocall(callBatchABI0, unsafe.Pointer(&batch{&frames[0], uint64(len(frames))}))
+	}
+}
+
+// SetCallback sets the address of the C function invoked by closures, it must
+// be called before any closure is created.
+func SetCallback(fn uintptr) {
+	callback = fn
+}
+
+// Closure is a piece of executable memory which calls the callback with its
+// context value in Regs.Ctx.
+type Closure struct {
+	mem []byte
+}
+
+func NewClosure(ctx uintptr) (*Closure, error) {
+	mem, err := syscall.Mmap(-1, 0, syscall.Getpagesize(), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+
+	// movabs r10, ctx
+	// movabs r11, closure
+	// jmp    r11
+	code := []byte{0x49, 0xba}
+	code = binary.LittleEndian.AppendUint64(code, uint64(ctx))
+	code = append(code, 0x49, 0xbb)
+	code = binary.LittleEndian.AppendUint64(code, uint64(closureABI0))
+	code = append(code, 0x41, 0xff, 0xe3)
+	copy(mem, code)
+
+	if err := syscall.Mprotect(mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+
+	return &Closure{mem}, nil
+}
+
+func (c *Closure) Pointer() unsafe.Pointer {
+	return unsafe.Pointer(&c.mem[0])
+}
+
+func (c *Closure) Free() error {
+	return syscall.Munmap(c.mem)
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+#include "textflag.h"
+#include "go_asm.h"
+
+// call(f *Frame) is called on the system stack with the C ABI, it loads the
+// argument registers and stack slots from the frame, calls f.Fn and stores the
+// return registers back into the frame.
+TEXT call<>(SB), NOSPLIT|NOFRAME, $0
+	PUSHQ BP
+	MOVQ  SP, BP
+	PUSHQ BX
+	PUSHQ R12
+	MOVQ  DI, BX
+
+	// Reserve the stack arguments area, keeping the stack 16 bytes aligned.
+	MOVQ Frame_NStack(BX), CX
+	MOVQ CX, AX
+	SHLQ $3, AX
+	ADDQ $15, AX
+	ANDQ $~15, AX
+	SUBQ AX, SP
+	LEAQ Frame_Stack(BX), SI
+	MOVQ SP, DI
+	REP; MOVSQ
+
+	MOVQ (Frame_SSE+0)(BX), X0
+	MOVQ (Frame_SSE+8)(BX), X1
+	MOVQ (Frame_SSE+16)(BX), X2
+	MOVQ (Frame_SSE+24)(BX), X3
+	MOVQ (Frame_SSE+32)(BX), X4
+	MOVQ (Frame_SSE+40)(BX), X5
+	MOVQ (Frame_SSE+48)(BX), X6
+	MOVQ (Frame_SSE+56)(BX), X7
+
+	MOVQ (Frame_Ints+0)(BX), DI
+	MOVQ (Frame_Ints+8)(BX), SI
+	MOVQ (Frame_Ints+16)(BX), DX
+	MOVQ (Frame_Ints+24)(BX), CX
+	MOVQ (Frame_Ints+32)(BX), R8
+	MOVQ (Frame_Ints+40)(BX), R9
+
+	// Variadic functions expect the number of vector registers in AL.
+	MOVQ Frame_NSSE(BX), AX
+	MOVQ Frame_Fn(BX), R10
+	CALL R10
+
+	MOVQ AX, Frame_RAX(BX)
+	MOVQ DX, Frame_RDX(BX)
+	MOVQ X0, Frame_XMM0(BX)
+	MOVQ X1, Frame_XMM1(BX)
+
+	LEAQ -16(BP), SP
+	POPQ R12
+	POPQ BX
+	POPQ BP
+	RET
+
+// callBatch(b *batch) calls each of the b.n frames starting at b.frames.
+TEXT callBatch<>(SB), NOSPLIT|NOFRAME, $0
+	PUSHQ BP
+	MOVQ  SP, BP
+	PUSHQ R12
+	PUSHQ R13
+	MOVQ  batch_frames(DI), R12
+	MOVQ  batch_n(DI), R13
+
+loop:
+	TESTQ R13, R13
+	JZ    done
+	MOVQ  R12, DI
+	CALL  call<>(SB)
+	ADDQ  $Frame__size, R12
+	DECQ  R13
+	JMP   loop
+
+done:
+	POPQ R13
+	POPQ R12
+	POPQ BP
+	RET
+
+// closure is the entry point of closures, it expects the context value in R10
+// and passes the saved registers to the callback.
+TEXT closure<>(SB), NOSPLIT|NOFRAME, $0
+	PUSHQ BP
+	MOVQ  SP, BP
+	SUBQ  $Regs__size, SP
+	ANDQ  $~15, SP
+
+	MOVQ R10, Regs_Ctx(SP)
+	LEAQ 16(BP), R11
+	MOVQ R11, Regs_Stack(SP)
+
+	MOVQ DI, (Regs_Ints+0)(SP)
+	MOVQ SI, (Regs_Ints+8)(SP)
+	MOVQ DX, (Regs_Ints+16)(SP)
+	MOVQ CX, (Regs_Ints+24)(SP)
+	MOVQ R8, (Regs_Ints+32)(SP)
+	MOVQ R9, (Regs_Ints+40)(SP)
+
+	MOVQ X0, (Regs_SSE+0)(SP)
+	MOVQ X1, (Regs_SSE+8)(SP)
+	MOVQ X2, (Regs_SSE+16)(SP)
+	MOVQ X3, (Regs_SSE+24)(SP)
+	MOVQ X4, (Regs_SSE+32)(SP)
+	MOVQ X5, (Regs_SSE+40)(SP)
+	MOVQ X6, (Regs_SSE+48)(SP)
+	MOVQ X7, (Regs_SSE+56)(SP)
+
+	MOVQ SP, DI
+	MOVQ ·callback(SB), AX
+	CALL AX
+
+	MOVQ Regs_RAX(SP), AX
+	MOVQ Regs_XMM0(SP), X0
+
+	MOVQ BP, SP
+	POPQ BP
+	RET
+
+DATA ·callABI0(SB)/8, $call<>(SB)
+GLOBL ·callABI0(SB), NOPTR|RODATA, $8
+
+DATA ·callBatchABI0(SB)/8, $callBatch<>(SB)
+GLOBL ·callBatchABI0(SB), NOPTR|RODATA, $8
+
+DATA ·closureABI0(SB)/8, $closure<>(SB)
+GLOBL ·closureABI0(SB), NOPTR|RODATA, $8
@@ -0,0 +1,398 @@
+//go:build !nolibffi
+
+package ffi
+
+// #include <ffi.h>
+// #include <stdint.h>
+// #include <stdlib.h>
+// #include <string.h>
+//
+// typedef void (*function)(void);
+//
+// static ffi_cif *ffi_cif_alloc__(size_t argc) {
+//   return calloc(1, sizeof(ffi_cif) + argc * sizeof(ffi_type *));
+// }
+//
+// static ffi_type **ffi_cif_args__(ffi_cif *cif) {
+//   return (ffi_type **) &cif[1];
+// }
+//
+// static uint64_t ffi_call0__(ffi_cif *cif, void *fptr) {
+//   uint64_t ret = 0;
+//   ffi_call(cif, (function)fptr, &ret, NULL);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call1__(ffi_cif *cif, void *fptr, uint64_t a1) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call2__(ffi_cif *cif, void *fptr, uint64_t a1, uint64_t a2) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1, &a2 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call3__(ffi_cif *cif, void *fptr, uint64_t a1, uint64_t a2, uint64_t a3) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1, &a2, &a3 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call4__(ffi_cif *cif, void *fptr, uint64_t a1, uint64_t a2, uint64_t a3, uint64_t a4) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1, &a2, &a3, &a4 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call5__(ffi_cif *cif, void *fptr, uint64_t a1, uint64_t a2, uint64_t a3, uint64_t a4, uint64_t a5) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1, &a2, &a3, &a4, &a5 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t ffi_call6__(ffi_cif *cif, void *fptr, uint64_t a1, uint64_t a2, uint64_t a3, uint64_t a4, uint64_t a5, uint64_t a6) {
+//   uint64_t ret = 0;
+//   void *args[] = { &a1, &a2, &a3, &a4, &a5, &a6 };
+//   ffi_call(cif, (function)fptr, &ret, args);
+//   return ret;
+// }
+//
+// static uint64_t *ffi_frame_alloc__(size_t argc) {
+//   uint64_t *frame;
+//   void **args;
+//   size_t i;
+//
+//   frame = calloc(1, (2 + argc) * sizeof(uint64_t) + argc * sizeof(void *));
+//
+//   if (frame != NULL) {
+//     args = (void **) &frame[2 + argc];
+//
+//     for (i = 0; i != argc; ++i) {
+//       args[i] = &frame[2 + i];
+//     }
+//   }
+//
+//   return frame;
+// }
+//
+// static void ffi_frame_call__(ffi_cif *cif, void *fptr, uint64_t *frame, size_t argc) {
+//   ffi_call(cif, (function)fptr, frame, (void **) &frame[2 + argc]);
+// }
+//
+// typedef struct {
+//   ffi_cif *cif;
+//   void *fptr;
+//   size_t args;
+//   size_t ret;
+// } ffi_batch_call__;
+//
+// static void ffi_batch_run__(size_t n, ffi_batch_call__ *calls, size_t *offsets, uint64_t *slots) {
+//   size_t i;
+//   unsigned j;
+//
+//   for (i = 0; i != n; ++i) {
+//     ffi_batch_call__ *c = &calls[i];
+//     void *args[c->cif->nargs + 1];
+//
+//     for (j = 0; j != c->cif->nargs; ++j) {
+//       args[j] = &slots[offsets[c->args + j]];
+//     }
+//
+//     ffi_call(c->cif, (function)c->fptr, &slots[c->ret], args);
+//   }
+// }
+//
+// static void ffi_map__(ffi_cif *cif, void *fptr, size_t n, void *out, size_t outsize, void **in, size_t *insize) {
+//   uint64_t ret[2];
+//   void *args[cif->nargs + 1];
+//   size_t i;
+//   unsigned j;
+//
+//   for (i = 0; i != n; ++i) {
+//     for (j = 0; j != cif->nargs; ++j) {
+//       args[j] = (char *) in[j] + (i * insize[j]);
+//     }
+//
+//     ffi_call(cif, (function)fptr, ret, args);
+//
+//     if (out != NULL) {
+//       memcpy((char *) out + (i * outsize), ret, outsize);
+//     }
+//   }
+// }
+//
+// static ffi_type *ffi_struct_alloc__(size_t n, size_t size, unsigned short alignment) {
+//   ffi_type *t = calloc(1, sizeof(ffi_type) + (n + 1) * sizeof(ffi_type *));
+//
+//   if (t != NULL) {
+//     t->size = size;
+//     t->alignment = alignment;
+//     t->type = FFI_TYPE_STRUCT;
+//     t->elements = (ffi_type **) &t[1];
+//   }
+//
+//   return t;
+// }
+//
+// static size_t ffi_ret_size__(ffi_type *t) {
+//   switch (t->type) {
+//   case FFI_TYPE_VOID:
+//     return 0;
+//
+//   case FFI_TYPE_INT:
+//   case FFI_TYPE_UINT8:
+//   case FFI_TYPE_SINT8:
+//   case FFI_TYPE_UINT16:
+//   case FFI_TYPE_SINT16:
+//   case FFI_TYPE_UINT32:
+//   case FFI_TYPE_SINT32:
+//   case FFI_TYPE_UINT64:
+//   case FFI_TYPE_SINT64:
+//     return t->size < sizeof(ffi_arg) ? sizeof(ffi_arg) : t->size;
+//
+//   default:
+//     return t->size;
+//   }
+// }
+//
+import "C"
+import (
+	"runtime"
+	"runtime/cgo"
+	"unsafe"
+)
+
+const (
+	OK         Status = Status(C.FFI_OK)
+	BadTypedef Status = Status(C.FFI_BAD_TYPEDEF)
+	BadABI     Status = Status(C.FFI_BAD_ABI)
+)
+
+type abiType = C.ffi_type
+
+var (
+	abiVoid = &C.ffi_type_void
+
+	abiUChar  = &C.ffi_type_uchar
+	abiUShort = &C.ffi_type_ushort
+	abiUInt   = &C.ffi_type_uint
+	abiULong  = &C.ffi_type_ulong
+
+	abiUInt8  = &C.ffi_type_uint8
+	abiUInt16 = &C.ffi_type_uint16
+	abiUInt32 = &C.ffi_type_uint32
+	abiUInt64 = &C.ffi_type_uint64
+
+	abiChar  = &C.ffi_type_schar
+	abiShort = &C.ffi_type_sshort
+	abiInt   = &C.ffi_type_sint
+	abiLong  = &C.ffi_type_slong
+
+	abiInt8  = &C.ffi_type_sint8
+	abiInt16 = &C.ffi_type_sint16
+	abiInt32 = &C.ffi_type_sint32
+	abiInt64 = &C.ffi_type_sint64
+
+	abiFloat  = &C.ffi_type_float
+	abiDouble = &C.ffi_type_double
+
+	abiPointer = &C.ffi_type_pointer
+
+	abiBool = &C.ffi_type_uint8
+
+	abiSizeT   = &C.ffi_type_ulong
+	abiSSizeT  = &C.ffi_type_slong
+	abiPtrDiff = &C.ffi_type_slong
+	abiIntPtr  = &C.ffi_type_slong
+
+	abiComplexFloat  = &C.ffi_type_complex_float
+	abiComplexDouble = &C.ffi_type_complex_double
+	abiLongDouble    = &C.ffi_type_longdouble
+)
+
+func (t Type) size() uintptr {
+	return uintptr(t.abi.size)
+}
+
+func (t Type) kind() int {
+	return int(t.abi._type)
+}
+
+func (t Type) retSize() uintptr {
+	return uintptr(C.ffi_ret_size__(t.abi))
+}
+
+func (t Type) align() uintptr {
+	return uintptr(t.abi.alignment)
+}
+
+// newStructABI allocates the libffi type of a struct with the given elements.
+// It lives in C memory for the lifetime of the program since the Type values
+// which reference it are freely copied.
+func newStructABI(size uintptr, align uintptr, elems []*abiType) *abiType {
+	t := C.ffi_struct_alloc__(C.size_t(len(elems)), C.size_t(size), C.ushort(align))
+
+	if t == nil {
+		panic("ffi: out of memory allocating struct type")
+	}
+
+	copy(unsafe.Slice(t.elements, len(elems)), elems)
+	return t
+}
+
+// abiInterface holds the libffi call interface, which lives in C memory along
+// with its array of argument types so it can be handed to C code (including
+// closures that outlive the Go values they were made from) without breaking
+// the cgo pointer passing rules.
+type abiInterface struct {
+	ffi *cifMemory
+}
+
+type cifMemory struct {
+	cif *C.ffi_cif
+}
+
+func (cif *Interface) prepare() Status {
+	argc := len(cif.args)
+	mem := C.ffi_cif_alloc__(C.size_t(argc))
+
+	if mem == nil {
+		panic("ffi: out of memory allocating call interface")
+	}
+
+	cif.ffi = &cifMemory{mem}
+
+	var va **C.ffi_type
+
+	if argc != 0 {
+		va = C.ffi_cif_args__(mem)
+
+		for i, a := range cif.args {
+			unsafe.Slice(va, argc)[i] = a.abi
+		}
+	}
+
+	return Status(C.ffi_prep_cif(mem, C.FFI_DEFAULT_ABI, C.uint(argc), cif.ret.abi, va))
+}
+
+func destroyInterface(mem *cifMemory) {
+	C.free(unsafe.Pointer(mem.cif))
+}
+
+// releaseOnGC frees the C memory of cif when it is garbage collected, for the
+// interfaces returned by Prepare which are copied freely.
+func (cif *Interface) releaseOnGC() {
+	runtime.SetFinalizer(cif.ffi, destroyInterface)
+}
+
+// release frees the C memory of cif immediately, it must not be used anymore.
+func (cif *Interface) release() {
+	if cif.ffi != nil {
+		destroyInterface(cif.ffi)
+		cif.ffi = nil
+	}
+}
+
+func (cif *Interface) call(fptr unsafe.Pointer, ret unsafe.Pointer, args []unsafe.Pointer) (err error) {
+	var va *unsafe.Pointer
+
+	if len(args) != 0 {
+		var pinner runtime.Pinner
+		defer pinner.Unpin()
+
+		for _, a := range args {
+			pinner.Pin(a)
+		}
+
+		va = &args[0]
+	}
+
+	_, err = C.ffi_call(cif.ffi.cif, C.function(fptr), ret, va)
+	runtime.KeepAlive(cif.ffi)
+	return
+}
+
+func (cif *Interface) callBits(fptr unsafe.Pointer, args []uint64) uint64 {
+	cif.checkBits()
+
+	var ret C.uint64_t
+
+	switch len(args) {
+	case 0:
+		ret = C.ffi_call0__(cif.ffi.cif, fptr)
+	case 1:
+		ret = C.ffi_call1__(cif.ffi.cif, fptr, C.uint64_t(args[0]))
+	case 2:
+		ret = C.ffi_call2__(cif.ffi.cif, fptr, C.uint64_t(args[0]), C.uint64_t(args[1]))
+	case 3:
+		ret = C.ffi_call3__(cif.ffi.cif, fptr, C.uint64_t(args[0]), C.uint64_t(args[1]), C.uint64_t(args[2]))
+	case 4:
+		ret = C.ffi_call4__(cif.ffi.cif, fptr, C.uint64_t(args[0]), C.uint64_t(args[1]), C.uint64_t(args[2]), C.uint64_t(args[3]))
+	case 5:
+		ret = C.ffi_call5__(cif.ffi.cif, fptr, C.uint64_t(args[0]), C.uint64_t(args[1]), C.uint64_t(args[2]), C.uint64_t(args[3]), C.uint64_t(args[4]))
+	case 6:
+		ret = C.ffi_call6__(cif.ffi.cif, fptr, C.uint64_t(args[0]), C.uint64_t(args[1]), C.uint64_t(args[2]), C.uint64_t(args[3]), C.uint64_t(args[4]), C.uint64_t(args[5]))
+	default:
+		panic("ffi: too many arguments for a typed call")
+	}
+
+	runtime.KeepAlive(cif.ffi)
+	return uint64(ret)
+}
+
+func (cif *Interface) mapCall(fptr unsafe.Pointer, n int, out unsafe.Pointer, outsize uintptr, in []unsafe.Pointer, insize []uintptr) {
+	C.ffi_map__(cif.ffi.cif, fptr, C.size_t(n), out, C.size_t(outsize), &in[0], (*C.size_t)(unsafe.Pointer(&insize[0])))
+	runtime.KeepAlive(cif.ffi)
+}
+
+type batchState struct{}
+
+func (b *Batch) run() {
+	for i := range b.calls {
+		b.calls[i].cif = unsafe.Pointer(b.cifs[i].ffi.cif)
+	}
+
+	var offsets *C.size_t
+
+	if len(b.offsets) != 0 {
+		offsets = (*C.size_t)(unsafe.Pointer(&b.offsets[0]))
+	}
+
+	C.ffi_batch_run__(C.size_t(len(b.calls)), (*C.ffi_batch_call__)(unsafe.Pointer(&b.calls[0])), offsets, (*C.uint64_t)(unsafe.Pointer(&b.slots[0])))
+	runtime.KeepAlive(b.cifs)
+}
+
+func (f *Frame) alloc() {
+	argc := len(f.cif.args)
+	mem := C.ffi_frame_alloc__(C.size_t(argc))
+
+	if mem == nil {
+		panic("ffi: out of memory allocating call frame")
+	}
+
+	f.mem = unsafe.Pointer(mem)
+	f.ret = (*[2]uint64)(f.mem)
+	f.args = unsafe.Slice((*uint64)(unsafe.Add(f.mem, 16)), argc)
+}
+
+func (f *Frame) call(fptr unsafe.Pointer) {
+	C.ffi_frame_call__(f.cif.ffi.cif, fptr, (*C.uint64_t)(f.mem), C.size_t(len(f.args)))
+	runtime.KeepAlive(f)
+}
+
+func destroyFrame(f *Frame) {
+	C.free(f.mem)
+}
+
+//export GoClosureCallback
+func GoClosureCallback(cif *C.ffi_cif, ret unsafe.Pointer, args *unsafe.Pointer, data unsafe.Pointer) {
+	cgo.Handle(uintptr(data)).Value().(*callback).invoke(ret, args)
+}
@@ -0,0 +1,214 @@
+package ffi
+
+// #include <float.h>
+// #include <math.h>
+// #include <stdint.h>
+// #include <string.h>
+//
+// enum {
+//   ffi_longdouble_size__ = sizeof(long double),
+//   ffi_longdouble_mant_dig__ = LDBL_MANT_DIG,
+// };
+//
+// enum {
+//   ffi_longdouble_finite__,
+//   ffi_longdouble_zero__,
+//   ffi_longdouble_inf__,
+//   ffi_longdouble_nan__,
+// };
+//
+// typedef struct {
+//   uint64_t hi;
+//   uint64_t lo;
+//   int exp;
+//   int neg;
+//   int kind;
+// } ffi_longdouble_parts__;
+//
+// static void ffi_longdouble_from_double__(void *p, double d) {
+//   long double x = d;
+//   memcpy(p, &x, sizeof(x));
+// }
+//
+// static double ffi_longdouble_to_double__(const void *p) {
+//   long double x;
+//   memcpy(&x, p, sizeof(x));
+//   return (double) x;
+// }
+//
+// // The value is decomposed with exact multiplications by powers of two so
+// // that no libm function is needed, the mantissa is returned as a 128 bits
+// // integer (hi, lo) such that |x| = 0.(hi)(lo) * 2^exp.
+// static ffi_longdouble_parts__ ffi_longdouble_split__(const void *p) {
+//   ffi_longdouble_parts__ r = {0, 0, 0, 0, ffi_longdouble_finite__};
+//   const long double two64 = 18446744073709551616.0L;
+//   long double x;
+//   memcpy(&x, p, sizeof(x));
+//
+//   r.neg = signbit(x) != 0;
+//
+//   if (x != x) {
+//     r.kind = ffi_longdouble_nan__;
+//     return r;
+//   }
+//
+//   if (r.neg) {
+//     x = -x;
+//   }
+//
+//   if (x == 0) {
+//     r.kind = ffi_longdouble_zero__;
+//     return r;
+//   }
+//
+//   if (x > LDBL_MAX) {
+//     r.kind = ffi_longdouble_inf__;
+//     return r;
+//   }
+//
+//   while (x >= two64) { x /= two64; r.exp += 64; }
+//   while (x < 1.0L / two64) { x *= two64; r.exp -= 64; }
+//   while (x >= 1) { x /= 2; r.exp++; }
+//   while (x < 0.5L) { x *= 2; r.exp--; }
+//
+//   x *= two64;
+//   r.hi = (uint64_t) x;
+//   r.lo = (uint64_t) ((x - (long double) r.hi) * two64);
+//   return r;
+// }
+//
+// static void ffi_longdouble_join__(void *p, ffi_longdouble_parts__ r) {
+//   const long double two64 = 18446744073709551616.0L;
+//   long double x;
+//
+//   switch (r.kind) {
+//   case ffi_longdouble_zero__:
+//     x = 0;
+//     break;
+//
+//   case ffi_longdouble_inf__:
+//     x = LDBL_MAX * 2;
+//     break;
+//
+//   default:
+//     x = ((long double) r.hi + (long double) r.lo / two64) / two64;
+//
+//     while (r.exp >= 64) { x *= two64; r.exp -= 64; }
+//     while (r.exp <= -64) { x /= two64; r.exp += 64; }
+//     while (r.exp > 0) { x *= 2; r.exp--; }
+//     while (r.exp < 0) { x /= 2; r.exp++; }
+//   }
+//
+//   if (r.neg) {
+//     x = -x;
+//   }
+//
+//   memcpy(p, &x, sizeof(x));
+// }
+//
+import "C"
+import (
+	"math/big"
+	"unsafe"
+)
+
+// LongDouble holds a value of the C long double type, which is an 80 bits
+// extended precision float on amd64. It is passed to C functions, received
+// from them and by closures like the Go scalar types.
+//
+// The memory of a long double may contain padding bytes, values must not be
+// compared with the == operator.
+type LongDouble struct {
+	mem [C.ffi_longdouble_size__]byte
+}
+
+// LongDoubleOf returns the long double value of f, the conversion is exact.
+func LongDoubleOf(f float64) (x LongDouble) {
+	C.ffi_longdouble_from_double__(unsafe.Pointer(&x.mem), C.double(f))
+	return
+}
+
+// LongDoubleOfBig returns the long double value nearest to f.
+func LongDoubleOfBig(f *big.Float) (x LongDouble) {
+	r := C.ffi_longdouble_parts__{neg: boolInt(f.Signbit())}
+
+	switch {
+	case f.IsInf():
+		r.kind = C.ffi_longdouble_inf__
+	case f.Sign() == 0:
+		r.kind = C.ffi_longdouble_zero__
+	default:
+		m := new(big.Float).SetPrec(C.ffi_longdouble_mant_dig__).SetMode(big.ToNearestEven).Abs(f)
+		e := m.MantExp(m)
+
+		// Exponents out of range are clamped to values which still overflow
+		// to infinity or underflow to zero.
+		e = max(e, C.LDBL_MIN_EXP-C.LDBL_MANT_DIG-1)
+		e = min(e, C.LDBL_MAX_EXP+1)
+
+		i, _ := m.SetMantExp(m, 128).Int(nil)
+		r.hi = C.uint64_t(new(big.Int).Rsh(i, 64).Uint64())
+		r.lo = C.uint64_t(i.Uint64())
+		r.exp = C.int(e)
+	}
+
+	C.ffi_longdouble_join__(unsafe.Pointer(&x.mem), r)
+	return
+}
+
+// Float64 returns the float64 value nearest to x.
+func (x LongDouble) Float64() float64 {
+	return float64(C.ffi_longdouble_to_double__(unsafe.Pointer(&x.mem)))
+}
+
+// Big returns the exact value of x, or nil if x is not a number.
+func (x LongDouble) Big() *big.Float {
+	r := C.ffi_longdouble_split__(unsafe.Pointer(&x.mem))
+	f := new(big.Float).SetPrec(C.ffi_longdouble_mant_dig__)
+
+	switch r.kind {
+	case C.ffi_longdouble_nan__:
+		return nil
+	case C.ffi_longdouble_inf__:
+		return f.SetInf(r.neg != 0)
+	case C.ffi_longdouble_finite__:
+		i := new(big.Int).SetUint64(uint64(r.hi))
+		i.Lsh(i, 64).Or(i, new(big.Int).SetUint64(uint64(r.lo)))
+		f.SetInt(i).SetMantExp(f, int(r.exp)-128)
+	}
+
+	if r.neg != 0 {
+		f.Neg(f)
+	}
+
+	return f
+}
+
+func (x LongDouble) String() string {
+	if f := x.Big(); f != nil {
+		return f.Text('g', -1)
+	}
+	return "NaN"
+}
+
+// FFIType satisfies the Marshaler interface.
+func (x LongDouble) FFIType() Type {
+	return LongDoubleType
+}
+
+// MarshalFFI satisfies the Marshaler interface.
+func (x LongDouble) MarshalFFI(p unsafe.Pointer) {
+	*(*[C.ffi_longdouble_size__]byte)(p) = x.mem
+}
+
+// UnmarshalFFI satisfies the Unmarshaler interface.
+func (x *LongDouble) UnmarshalFFI(p unsafe.Pointer) {
+	x.mem = *(*[C.ffi_longdouble_size__]byte)(p)
+}
+
+func boolInt(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// Map calls the C function at fptr once for each index of the input slices,
+// passing the elements of in as arguments and storing the return values in
+// out. The calls are made from a loop running on the C side so the cost of the
+// cgo transition is not paid for each element.
+//
+// The slices must all have the same length and their element types must match
+// the types declared by cif, out must be nil if the function returns void.
+func Map(cif Interface, fptr unsafe.Pointer, out interface{}, in ...interface{}) {
+	if len(in) != len(cif.args) {
+		panic(fmt.Sprintf("ffi: wrong number of input slices, expected %d but got %d", len(cif.args), len(in)))
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+
+	n := -1
+	vout := reflect.ValueOf(out)
+	pout := unsafe.Pointer(nil)
+	sout := uintptr(0)
+
+	if cif.ret.abi == Void.abi {
+		if out != nil {
+			panic("ffi: output slice given for function returning void")
+		}
+	} else {
+		checkMapSlice(vout, cif.ret, "output")
+		n = vout.Len()
+		pout = mapSlicePointer(vout, &pinner)
+		sout = vout.Type().Elem().Size()
+	}
+
+	pin := make([]unsafe.Pointer, len(in)+1)
+	sin := make([]uintptr, len(in)+1)
+
+	for i, a := range in {
+		v := reflect.ValueOf(a)
+		checkMapSlice(v, cif.args[i], fmt.Sprintf("input %d", i))
+
+		if n < 0 {
+			n = v.Len()
+		} else if n != v.Len() {
+			panic(fmt.Sprintf("ffi: mismatching slice lengths, expected %d but input %d has %d elements", n, i, v.Len()))
+		}
+
+		pin[i] = mapSlicePointer(v, &pinner)
+		sin[i] = v.Type().Elem().Size()
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	cif.mapCall(fptr, n, pout, sout, pin, sin)
+}
+
+func checkMapSlice(v reflect.Value, t Type, what string) {
+	if v.Kind() != reflect.Slice {
+		panic(fmt.Sprintf("ffi: expected %s to be a slice but got %s", what, describeValue(v)))
+	}
+
+	switch e := v.Type().Elem(); e.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.Complex64, reflect.Complex128,
+		reflect.UnsafePointer:
+		if et := makeArgType(reflect.Zero(e)); !sameType(et, t) {
+			panic(fmt.Sprintf("ffi: element type of %s is %s (%s) but the function expects %s", what, e, et, t))
+		}
+
+	default:
+		panic(fmt.Sprintf("ffi: unsupported element type of %s: %s", what, e))
+	}
+}
+
+func mapSlicePointer(v reflect.Value, pinner *runtime.Pinner) unsafe.Pointer {
+	if v.Len() == 0 {
+		return nil
+	}
+
+	p := v.Index(0).Addr().UnsafePointer()
+	pinner.Pin(p)
+	return p
+}
+
+func describeValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+// sameType compares types by their ABI, so that for example Long and Int64 are
+// considered identical on platforms where they have the same representation.
+func sameType(t1 Type, t2 Type) bool {
+	return t1.size() == t2.size() && t1.kind() == t2.kind()
+}
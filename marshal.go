@@ -0,0 +1,69 @@
+package ffi
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// Marshaler is implemented by Go types which are passed to C as values of the
+// C type returned by FFIType. MarshalFFI writes the C representation of the
+// value to the memory at p, which is large enough to hold a value of the type.
+//
+// Call uses it for arguments, closures for the values they return.
+type Marshaler interface {
+	FFIType() Type
+	MarshalFFI(p unsafe.Pointer)
+}
+
+// Unmarshaler is implemented by pointers to Go types which are received from
+// C as values of the C type returned by FFIType. UnmarshalFFI reads the C
+// representation of the value from the memory at p.
+//
+// Call uses it for return values, closures for the arguments they receive.
+type Unmarshaler interface {
+	FFIType() Type
+	UnmarshalFFI(p unsafe.Pointer)
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// marshalerOf returns v as a Marshaler. Pointers to types implementing the
+// interface are still passed as pointers.
+func marshalerOf(v reflect.Value) (Marshaler, bool) {
+	if !v.IsValid() || !v.Type().Implements(marshalerType) || !v.CanInterface() {
+		return nil, false
+	}
+
+	if v.Kind() == reflect.Ptr && (v.IsNil() || v.Type().Elem().Implements(marshalerType)) {
+		return nil, false
+	}
+
+	return v.Interface().(Marshaler), true
+}
+
+// isUnmarshaler returns true if values of type t are received through the
+// Unmarshaler implementation of *t.
+func isUnmarshaler(t reflect.Type) bool {
+	return reflect.PtrTo(t).Implements(unmarshalerType)
+}
+
+func unmarshalerFFIType(t reflect.Type) Type {
+	return reflect.New(t).Interface().(Unmarshaler).FFIType()
+}
+
+func unmarshalGoValue(p unsafe.Pointer, t reflect.Type) reflect.Value {
+	v := reflect.New(t)
+	v.Interface().(Unmarshaler).UnmarshalFFI(p)
+	return v.Elem()
+}
+
+// marshaledSize returns the size of the slot needed to hold the value of type t.
+func marshaledSize(t Type, min uintptr) uintptr {
+	if size := t.size(); size > min {
+		return size
+	}
+	return min
+}
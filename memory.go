@@ -0,0 +1,132 @@
+package ffi
+
+// #include <stdlib.h>
+import "C"
+import (
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// Malloc allocates size bytes of C memory, which must be released with Free.
+func Malloc(size uintptr) unsafe.Pointer {
+	p := C.malloc(C.size_t(size))
+
+	if p == nil && size != 0 {
+		panic("ffi: out of memory")
+	}
+
+	return p
+}
+
+// Calloc allocates zeroed C memory for an array of n elements of the given
+// size, which must be released with Free.
+func Calloc(n uintptr, size uintptr) unsafe.Pointer {
+	p := C.calloc(C.size_t(n), C.size_t(size))
+
+	if p == nil && n != 0 && size != 0 {
+		panic("ffi: out of memory")
+	}
+
+	return p
+}
+
+// Free releases C memory allocated by Malloc, Calloc or by C code using the
+// malloc family of functions.
+func Free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+// Memcpy copies n bytes from src to dst, either of which may point to Go or C
+// memory. The memory areas may overlap.
+func Memcpy(dst unsafe.Pointer, src unsafe.Pointer, n uintptr) {
+	if n != 0 {
+		copy(unsafe.Slice((*byte)(dst), n), unsafe.Slice((*byte)(src), n))
+	}
+}
+
+// SliceOf returns a Go slice of n values of type T viewing the memory at p,
+// which is typically C memory. The slice is only valid as long as the memory
+// is.
+func SliceOf[T any](p unsafe.Pointer, n int) []T {
+	if p == nil {
+		return nil
+	}
+	return unsafe.Slice((*T)(p), n)
+}
+
+// Buffer is a block of C memory owned by Go, it is released when the buffer is
+// garbage collected or when Free is called. Buffers returned by functions
+// declared with Borrowed ownership reference memory that they do not release.
+//
+// The views returned by Bytes and SliceOf do not keep the buffer alive, the
+// program must make sure it is reachable while they are in use, for example
+// with runtime.KeepAlive.
+type Buffer struct {
+	ptr  unsafe.Pointer
+	size uintptr
+	own  Ownership
+}
+
+// NewBuffer allocates a zeroed buffer of size bytes.
+func NewBuffer(size uintptr) *Buffer {
+	b := &Buffer{
+		ptr:  Calloc(1, size),
+		size: size,
+		own:  Freed,
+	}
+	runtime.SetFinalizer(b, (*Buffer).Free)
+	return b
+}
+
+// BufferOf allocates a buffer holding a copy of data.
+func BufferOf(data []byte) *Buffer {
+	b := NewBuffer(uintptr(len(data)))
+	copy(b.Bytes(), data)
+	return b
+}
+
+// Pointer returns the address of the buffer memory, or nil after Free was
+// called.
+func (b *Buffer) Pointer() unsafe.Pointer {
+	return b.ptr
+}
+
+func (b *Buffer) cPointer() unsafe.Pointer {
+	return b.Pointer()
+}
+
+// Len returns the size of the buffer in bytes.
+func (b *Buffer) Len() int {
+	return int(b.size)
+}
+
+// Bytes returns a Go slice viewing the buffer memory.
+func (b *Buffer) Bytes() []byte {
+	return SliceOf[byte](b.ptr, int(b.size))
+}
+
+// Free releases the buffer memory, calling it more than once is a no-op.
+func (b *Buffer) Free() {
+	if b.ptr != nil {
+		b.own.release(b.ptr)
+		b.ptr, b.size = nil, 0
+		runtime.SetFinalizer(b, nil)
+	}
+}
+
+// cMemory is implemented by the Go values which own C memory, like buffers and
+// handles.
+// They are passed to C functions as the pointer to that memory instead of the
+// address of the Go value.
+type cMemory interface {
+	cPointer() unsafe.Pointer
+}
+
+func cMemoryOf(v reflect.Value) (cMemory, bool) {
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, false
+	}
+	m, ok := v.Interface().(cMemory)
+	return m, ok
+}
@@ -0,0 +1,84 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Out wraps a pointer to a Go variable passed to Call for a C out-parameter.
+// The function receives the address of a C value of the type matching the
+// variable (for example an int for a Go int), which is converted and stored
+// in the variable after the call.
+//
+// Like the return values of Call, the variable may be a *Buffer or a *Handle
+// receiving the pointer stored by the function.
+func Out(ptr interface{}) interface{} {
+	return OutOwned(ptr, Borrowed)
+}
+
+// OutOwned is like Out but declares the ownership of the memory that the
+// function stores in the out-parameter, like Return does for return values.
+// For example, the buffer allocated by posix_memalign is received with:
+//
+//	var b *ffi.Buffer
+//	ffi.Call(posix_memalign, &res, ffi.OutOwned(&b, ffi.Freed), uintptr(64), uintptr(1024))
+func OutOwned(ptr interface{}, own Ownership) interface{} {
+	v := valueOfOut(ptr, "Out", false)
+	own.checkRetType(v.Type())
+	return outValue{ptr: v, own: own}
+}
+
+// InOut is like Out but the C value is initialized from the Go variable before
+// the call.
+//
+// Strings are copied to memory which belongs to the call, the function may
+// modify them in place but must not free or reallocate them. Functions which
+// grow the buffers they are given with realloc, like getline, are not
+// supported.
+func InOut(ptr interface{}) interface{} {
+	return outValue{ptr: valueOfOut(ptr, "InOut", true), in: true}
+}
+
+type outValue struct {
+	ptr reflect.Value
+	in  bool
+	own Ownership
+}
+
+var outType = reflect.TypeOf(outValue{})
+
+func valueOfOut(ptr interface{}, name string, in bool) reflect.Value {
+	v := reflect.ValueOf(ptr)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		panic(fmt.Sprintf("ffi: %s expects a non-nil pointer but got %T", name, ptr))
+	}
+
+	if t := v.Elem().Type(); t.Kind() == reflect.Ptr && (in || (t != bufferType && t != handleType)) {
+		panic(fmt.Sprintf("ffi: unsupported %s parameter type: %s", name, v.Type()))
+	}
+
+	makeRetType(v)
+	return v
+}
+
+func makeOutValue(mem *arena, v reflect.Value) unsafe.Pointer {
+	out := v.Interface().(outValue)
+
+	if out.in {
+		return makeArgValue(mem, out.ptr.Elem())
+	}
+
+	return mem.alloc(argSlotSize)
+}
+
+func setOutValues(args []reflect.Value, argv []unsafe.Pointer) {
+	for i, a := range args {
+		if a.Kind() == reflect.Struct && a.Type() == outType {
+			out := a.Interface().(outValue)
+			setRetValue(out.ptr, *(*unsafe.Pointer)(argv[i]))
+			out.own.setRetValue(out.ptr, *(*unsafe.Pointer)(argv[i]))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// Ownership declares who owns the memory that a C function returns a pointer
+// to, and how it is released when it is owned by the caller.
+type Ownership struct {
+	owned bool
+	free  unsafe.Pointer
+	fn    Function
+}
+
+var (
+	// Borrowed memory remains owned by the C library, strings are copied
+	// but never released. This is the default for values returned by Call
+	// and by bound functions.
+	Borrowed = Ownership{}
+
+	// Freed memory is owned by the caller and released with free(3).
+	Freed = Ownership{owned: true}
+)
+
+// FreedWith declares memory owned by the caller and released by the C
+// function at fptr, which must have the signature void (*)(void *).
+func FreedWith(fptr unsafe.Pointer) Ownership {
+	return Ownership{owned: true, free: fptr}
+}
+
+// FreedBy is like FreedWith but takes the destructor as a Function, like the
+// closures created with Closure, which is kept alive as long as the ownership
+// is in use.
+func FreedBy(fn Function) Ownership {
+	return Ownership{owned: true, free: unsafe.Pointer(fn.Pointer()), fn: fn}
+}
+
+// Return wraps the return value pointer passed to Call to declare the
+// ownership of the memory returned by the function.
+//
+// When ret is a *string or a **string, owned memory is released right after the string was
+// copied to Go. When ret is a **Buffer, it receives a buffer referencing the
+// returned memory, owned buffers release it when they are freed or garbage
+// collected. The size of the memory is unknown so the buffer length is zero,
+// its content can be accessed with SliceOf. When ret is a **Handle, it
+// receives a handle owning the returned pointer, or nil if it was NULL.
+func Return(ret interface{}, own Ownership) interface{} {
+	return returnValue{ret, own}
+}
+
+// WithReturn returns a copy of cif where functions bound with Bind declare the
+// ownership of the memory they return, like values wrapped with Return when
+// calling Call.
+func (cif Interface) WithReturn(own Ownership) Interface {
+	cif.own = own
+	return cif
+}
+
+type returnValue struct {
+	ret interface{}
+	own Ownership
+}
+
+var (
+	bufferType    = reflect.TypeOf((*Buffer)(nil))
+	handleType    = reflect.TypeOf((*Handle)(nil))
+	freeInterface = Prepare(Void, Pointer)
+)
+
+func valueOfOwnedRet(ret interface{}) (reflect.Value, Ownership, Encoding) {
+	own := Borrowed
+	enc := UTF8
+
+	if r, ok := ret.(returnValue); ok {
+		ret, own = r.ret, r.own
+	}
+
+	if e, ok := ret.(encodedValue); ok {
+		if e.value.Kind() != reflect.Ptr || !isStringType(e.value.Type().Elem()) {
+			panic(fmt.Sprintf("ffi: only *string and **string return values can be encoded but got %s", describeValue(e.value)))
+		}
+		ret, enc = e.value.Interface(), e.enc
+	}
+
+	v := valueOfRet(ret)
+
+	if v.IsValid() {
+		own.checkRetType(v.Type())
+	}
+
+	return v, own, enc
+}
+
+// checkRetType panics if own declares the caller as owner of the memory
+// returned through pointers of type t, which cannot hold owned memory.
+func (own Ownership) checkRetType(t reflect.Type) {
+	if !own.owned {
+		return
+	}
+
+	switch e := t.Elem(); {
+	case e.Kind() == reflect.String:
+	case e.Kind() == reflect.Ptr && e.Elem().Kind() == reflect.String:
+	case e == bufferType:
+	case e == handleType:
+	default:
+		panic(fmt.Sprintf("ffi: ownership can only be declared for *string, **string, **ffi.Buffer and **ffi.Handle return values but got %s", t))
+	}
+}
+
+func (own Ownership) setRetValue(v reflect.Value, p unsafe.Pointer) {
+	if !v.IsValid() {
+		return
+	}
+
+	switch v = v.Elem(); {
+	case v.Kind() == reflect.String:
+		own.release(*(*unsafe.Pointer)(p))
+
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.String:
+		own.release(*(*unsafe.Pointer)(p))
+
+	case v.Type() == bufferType:
+		b := &Buffer{ptr: *(*unsafe.Pointer)(p), own: own}
+
+		if own.owned && b.ptr != nil {
+			runtime.SetFinalizer(b, (*Buffer).Free)
+		}
+
+		v.Set(reflect.ValueOf(b))
+
+	case v.Type() == handleType:
+		var h *Handle
+
+		if ptr := *(*unsafe.Pointer)(p); ptr != nil {
+			h = NewOwned[unsafe.Pointer](ptr, own)
+		}
+
+		v.Set(reflect.ValueOf(h))
+	}
+}
+
+func (own Ownership) release(p unsafe.Pointer) {
+	switch {
+	case !own.owned || p == nil:
+	case own.free == nil:
+		Free(p)
+	default:
+		Call1[uintptr](&freeInterface, own.free, p)
+		runtime.KeepAlive(own.fn)
+	}
+}
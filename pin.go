@@ -0,0 +1,124 @@
+package ffi
+
+import (
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// Pinned wraps an argument of Call so that all the Go memory reachable from it
+// is pinned for the duration of the call, which allows passing Go values that
+// hold Go pointers to C without copying them.
+func Pinned(v interface{}) interface{} {
+	return pinned{v}
+}
+
+type pinned struct {
+	value interface{}
+}
+
+// Pins keeps Go memory pinned beyond the duration of a call, for C code that
+// holds on to the pointers it was given and uses them asynchronously.
+//
+// The zero value is ready to use, Release must be called once C no longer
+// uses the memory.
+type Pins struct {
+	pinner runtime.Pinner
+}
+
+// Pin pins all the Go memory reachable from v and returns v, so it can be used
+// inline in the arguments of Call.
+func (p *Pins) Pin(v interface{}) interface{} {
+	pinValue(&p.pinner, reflect.ValueOf(v), make(map[pinKey]struct{}))
+	return v
+}
+
+// Release unpins all the memory pinned by p.
+func (p *Pins) Release() {
+	p.pinner.Unpin()
+}
+
+func pinArgValues(args []reflect.Value, pinner *runtime.Pinner) {
+	var seen map[pinKey]struct{}
+
+	for i, a := range args {
+		if a.Kind() != reflect.Struct || a.Type() != reflect.TypeOf(pinned{}) {
+			continue
+		}
+
+		if seen == nil {
+			seen = make(map[pinKey]struct{})
+		}
+
+		args[i] = reflect.ValueOf(a.Interface().(pinned).value)
+		pinValue(pinner, args[i], seen)
+	}
+}
+
+type pinKey struct {
+	addr unsafe.Pointer
+	typ  reflect.Type
+}
+
+// pinValue pins the memory referenced by v and recursively the memory that it
+// references in turn, seen prevents walking cycles more than once.
+func pinValue(pinner *runtime.Pinner, v reflect.Value, seen map[pinKey]struct{}) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !visit(seen, v.UnsafePointer(), v.Type()) {
+			return
+		}
+		pinner.Pin(v.UnsafePointer())
+		pinValue(pinner, v.Elem(), seen)
+
+	case reflect.UnsafePointer:
+		if p := v.UnsafePointer(); p != nil {
+			pinner.Pin(p)
+		}
+
+	case reflect.String:
+		if v.Len() != 0 {
+			pinner.Pin(unsafe.StringData(v.String()))
+		}
+
+	case reflect.Slice:
+		if v.Len() == 0 || !visit(seen, v.UnsafePointer(), v.Type()) {
+			return
+		}
+		pinner.Pin(v.UnsafePointer())
+
+		if hasPointers(v.Type().Elem()) {
+			for i, n := 0, v.Len(); i != n; i++ {
+				pinValue(pinner, v.Index(i), seen)
+			}
+		}
+
+	case reflect.Array:
+		if hasPointers(v.Type().Elem()) {
+			for i, n := 0, v.Len(); i != n; i++ {
+				pinValue(pinner, v.Index(i), seen)
+			}
+		}
+
+	case reflect.Struct:
+		for i, n := 0, v.NumField(); i != n; i++ {
+			pinValue(pinner, v.Field(i), seen)
+		}
+
+	case reflect.Interface:
+		if !v.IsNil() {
+			pinValue(pinner, v.Elem(), seen)
+		}
+	}
+}
+
+func visit(seen map[pinKey]struct{}, addr unsafe.Pointer, typ reflect.Type) bool {
+	k := pinKey{addr, typ}
+
+	if _, ok := seen[k]; ok {
+		return false
+	}
+
+	seen[k] = struct{}{}
+	return true
+}
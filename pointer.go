@@ -0,0 +1,217 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PointerTo returns a pointer type to values of type t. It has the same ABI as
+// Pointer, the pointed type is part of its String (like "char *") and is used
+// by FormatValue and Interface.FormatCall to show the pointed values instead
+// of addresses. It also validates the arguments of bound functions in strict
+// mode, and lets closures created with Interface.Closure receive the value
+// that the pointer points to.
+func PointerTo(t Type) Type {
+	name := t.String()
+
+	if strings.HasSuffix(name, "*") {
+		name += "*"
+	} else {
+		name += " *"
+	}
+
+	return Type{abi: Pointer.abi, name: name, elem: &t}
+}
+
+// Elem returns the type that t points to, and false if t was not created by
+// PointerTo.
+func (t Type) Elem() (Type, bool) {
+	if t.elem == nil {
+		return Type{}, false
+	}
+	return *t.elem, true
+}
+
+// TypeError is returned in strict mode when a pointer argument refers to Go
+// values which do not have the type pointed to by the C argument.
+type TypeError struct {
+	// Index of the argument in the C function signature.
+	Index int
+	// Type of the Go value passed for the argument.
+	Value reflect.Type
+	// Type of the C argument.
+	Type Type
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("ffi: argument %d (%s) does not match %s", e.Index, e.Value, e.Type)
+}
+
+// pointsTo returns true if the Go value v can be passed to C for a pointer to
+// values of type elem. Values which do not carry a Go type, like nil or
+// unsafe.Pointer, are always accepted.
+func pointsTo(v reflect.Value, elem Type) bool {
+	if elem.abi == nil || elem.abi == Void.abi {
+		return true
+	}
+
+	enc := UTF8
+
+	if v.Kind() == reflect.Struct {
+		switch v.Type() {
+		case outType:
+			return sameType(makeRetType(v.Interface().(outValue).ptr), elem)
+		case encodedType:
+			v, enc = valueOfEncoded(v)
+		default:
+			return true
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return isCharType(elem, enc)
+
+	case reflect.Ptr, reflect.Slice:
+		if _, ok := cMemoryOf(v); ok {
+			// the type of the C memory is unknown
+			return true
+		}
+
+		t := v.Type().Elem()
+
+		switch {
+		case t.Kind() == reflect.String && v.Kind() == reflect.Ptr:
+			return isCharType(elem, enc)
+		case t.Kind() == reflect.String:
+			return sameType(elem, Pointer)
+		default:
+			return sameGoType(t, elem)
+		}
+	}
+
+	return true
+}
+
+// sameGoType returns true if Go values of type t have the memory layout of C
+// values of type elem.
+func sameGoType(t reflect.Type, elem Type) bool {
+	if t.Size() != elem.size() {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// the signedness does not change the memory layout, a []byte can be
+		// passed for a char *
+		_, _, ok := intRange(elem)
+		return ok
+
+	case reflect.Float32, reflect.Float64, reflect.Bool, reflect.Complex64, reflect.Complex128,
+		reflect.UnsafePointer:
+		return sameType(makeArgType(reflect.Zero(t)), elem)
+	}
+
+	return true
+}
+
+func isCharType(t Type, enc Encoding) bool {
+	_, _, ok := intRange(t)
+	return ok && t.size() == enc.unitSize()
+}
+
+// derefs returns true if a closure receives the value pointed to by the C
+// argument of type t as a Go parameter of type in.
+func derefs(t Type, in reflect.Type) bool {
+	if t.elem == nil {
+		return false
+	}
+
+	switch in.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Slice, reflect.String:
+		return false
+	}
+
+	return sameType(makeArgType(reflect.Zero(in)), *t.elem)
+}
+
+// formatPointer formats the Go value v passed for a C pointer. Strings are
+// quoted, and the values that Go pointers and slices refer to are shown when
+// the pointed type elem is known.
+func formatPointer(v reflect.Value, elem *Type) string {
+	if v.Kind() == reflect.Struct && v.Type() == outType {
+		v = v.Interface().(outValue).ptr
+	}
+
+	if m, ok := cMemoryOf(v); ok {
+		return formatCMemory(m)
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return "NULL"
+
+	case reflect.String:
+		return strconv.Quote(v.String())
+
+	case reflect.UnsafePointer:
+		if p := v.UnsafePointer(); p != nil {
+			return fmt.Sprintf("%p", p)
+		}
+		return "NULL"
+
+	case reflect.Ptr:
+		switch {
+		case v.IsNil():
+			return "NULL"
+		case v.Type().Elem().Kind() == reflect.String:
+			return strconv.Quote(v.Elem().String())
+		case elem != nil:
+			return "&" + elem.FormatValue(v.Elem().Interface())
+		}
+		return fmt.Sprintf("%p", v.Interface())
+
+	case reflect.Slice:
+		switch {
+		case v.IsNil():
+			return "NULL"
+		case v.Type().Elem().Kind() == reflect.Uint8 && elem != nil && isCharType(*elem, UTF8):
+			return strconv.Quote(string(v.Bytes()))
+		}
+
+		items := make([]string, v.Len())
+
+		for i := range items {
+			if e := v.Index(i); e.Kind() == reflect.String {
+				items[i] = strconv.Quote(e.String())
+			} else if elem != nil {
+				items[i] = elem.FormatValue(e.Interface())
+			} else {
+				items[i] = fmt.Sprint(e.Interface())
+			}
+		}
+
+		return "[" + strings.Join(items, " ") + "]"
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// formatCMemory formats the address of the C memory owned by m, handles which
+// were closed have none.
+func formatCMemory(m cMemory) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = "<closed>"
+		}
+	}()
+
+	if p := m.cPointer(); p != nil {
+		return fmt.Sprintf("%p", p)
+	}
+
+	return "NULL"
+}
@@ -0,0 +1,362 @@
+package ffi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+// Field is a named member of a struct type created with StructOf.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// aggregate describes the layout of struct and array types.
+type aggregate struct {
+	fields  []Field
+	offsets []uintptr
+	elem    Type
+	len     int
+	align   uintptr
+}
+
+// StructOf returns a struct type named name with the given fields, laid out
+// like a C compiler does. Struct types can be passed by value to functions
+// called through the libffi backend, and describe the memory of Record values.
+func StructOf(name string, fields ...Field) Type {
+	if len(fields) == 0 {
+		panic(fmt.Sprintf("ffi: struct %s must have at least one field", name))
+	}
+
+	agg := &aggregate{
+		fields:  append([]Field(nil), fields...),
+		offsets: make([]uintptr, len(fields)),
+		align:   1,
+	}
+
+	elems := make([]*abiType, len(fields))
+	size := uintptr(0)
+
+	for i, f := range fields {
+		if f.Type.abi == nil || f.Type.abi == Void.abi {
+			panic(fmt.Sprintf("ffi: invalid type of field %s of struct %s: %s", f.Name, name, f.Type))
+		}
+
+		for _, g := range fields[:i] {
+			if g.Name == f.Name {
+				panic(fmt.Sprintf("ffi: duplicate field %s in struct %s", f.Name, name))
+			}
+		}
+
+		a := f.Type.alignment()
+		size = alignUp(size, a)
+		agg.offsets[i] = size
+		size += f.Type.size()
+		agg.align = max(agg.align, a)
+		elems[i] = f.Type.abi
+	}
+
+	if name == "" {
+		name = "struct"
+	}
+
+	size = alignUp(size, agg.align)
+	return Type{abi: newStructABI(size, agg.align, elems), name: name, agg: agg}
+}
+
+// ArrayOf returns the type of arrays of n values of type t, which can be used
+// as the type of struct fields.
+func ArrayOf(t Type, n int) Type {
+	if n <= 0 || t.abi == nil || t.abi == Void.abi {
+		panic(fmt.Sprintf("ffi: invalid array type %s[%d]", t, n))
+	}
+
+	elems := make([]*abiType, n)
+
+	for i := range elems {
+		elems[i] = t.abi
+	}
+
+	agg := &aggregate{elem: t, len: n, align: t.alignment()}
+	name := t.String() + "[" + strconv.Itoa(n) + "]"
+	return Type{abi: newStructABI(uintptr(n)*t.size(), agg.align, elems), name: name, agg: agg}
+}
+
+// Size returns the size of values of type t in bytes.
+func (t Type) Size() uintptr {
+	return t.size()
+}
+
+func (t Type) alignment() uintptr {
+	if t.agg != nil {
+		return t.agg.align
+	}
+	return t.align()
+}
+
+func alignUp(n uintptr, a uintptr) uintptr {
+	return (n + a - 1) &^ (a - 1)
+}
+
+// Record is a value of a struct or array type stored in C memory, whose fields
+// or elements are accessed by name or index, like the Structure class of
+// Python's ctypes. It is meant for types whose layout is only known at runtime,
+// for which no Go type can be declared.
+//
+// Records are passed by value when given to Call, pass their Pointer to pass
+// them by reference.
+type Record struct {
+	typ Type
+	ptr unsafe.Pointer
+	mem *Buffer
+}
+
+// NewRecord allocates a zeroed record of type t in C memory, which is released
+// when the record and the records viewing its fields are garbage collected.
+func NewRecord(t Type) *Record {
+	if t.agg == nil {
+		panic(fmt.Sprintf("ffi: records can only be created for struct or array types but got %s", t))
+	}
+	mem := NewBuffer(t.size())
+	return &Record{typ: t, ptr: mem.Pointer(), mem: mem}
+}
+
+// RecordAt returns a record of type t viewing the memory at p, which must be
+// valid for as long as the record is used.
+func RecordAt(t Type, p unsafe.Pointer) *Record {
+	if t.agg == nil {
+		panic(fmt.Sprintf("ffi: records can only be created for struct or array types but got %s", t))
+	}
+	return &Record{typ: t, ptr: p}
+}
+
+// Type returns the type of r.
+func (r *Record) Type() Type {
+	return r.typ
+}
+
+// Pointer returns the address of the record memory.
+func (r *Record) Pointer() unsafe.Pointer {
+	return r.ptr
+}
+
+// Len returns the number of elements of an array record, or the number of
+// fields of a struct record.
+func (r *Record) Len() int {
+	if r.typ.agg.fields != nil {
+		return len(r.typ.agg.fields)
+	}
+	return r.typ.agg.len
+}
+
+// Get returns the value of the field with the given name, converted to the Go
+// type of the same size as its C type (for example int32 for a C int). Struct
+// and array fields are returned as records viewing the memory of r.
+func (r *Record) Get(name string) interface{} {
+	f, p := r.field(name)
+	return r.load(p, f.Type)
+}
+
+// Set assigns v to the field with the given name. Numbers are converted to the
+// C type of the field, records are copied.
+func (r *Record) Set(name string, v interface{}) {
+	f, p := r.field(name)
+	store(p, f.Type, reflect.ValueOf(v), name)
+}
+
+// Index returns the element at index i of an array record, converted like the
+// values returned by Get.
+func (r *Record) Index(i int) interface{} {
+	elem, p := r.index(i)
+	return r.load(p, elem)
+}
+
+// SetIndex assigns v to the element at index i of an array record.
+func (r *Record) SetIndex(i int, v interface{}) {
+	elem, p := r.index(i)
+	store(p, elem, reflect.ValueOf(v), fmt.Sprintf("[%d]", i))
+}
+
+// FFIType satisfies the Marshaler and Unmarshaler interfaces.
+func (r *Record) FFIType() Type {
+	return r.typ
+}
+
+// MarshalFFI satisfies the Marshaler interface.
+func (r *Record) MarshalFFI(p unsafe.Pointer) {
+	Memcpy(p, r.ptr, r.typ.size())
+}
+
+// UnmarshalFFI satisfies the Unmarshaler interface.
+func (r *Record) UnmarshalFFI(p unsafe.Pointer) {
+	Memcpy(r.ptr, p, r.typ.size())
+}
+
+func (r *Record) String() string {
+	return fmt.Sprintf("%s@%p", r.typ, r.ptr)
+}
+
+func (r *Record) field(name string) (Field, unsafe.Pointer) {
+	for i, f := range r.typ.agg.fields {
+		if f.Name == name {
+			return f, unsafe.Add(r.ptr, r.typ.agg.offsets[i])
+		}
+	}
+	panic(fmt.Sprintf("ffi: %s has no field named %s", r.typ, name))
+}
+
+func (r *Record) index(i int) (Type, unsafe.Pointer) {
+	if r.typ.agg.fields != nil || i < 0 || i >= r.typ.agg.len {
+		panic(fmt.Sprintf("ffi: index %d out of range of %s", i, r.typ))
+	}
+	elem := r.typ.agg.elem
+	return elem, unsafe.Add(r.ptr, uintptr(i)*elem.size())
+}
+
+// load converts the C value of type t at p, which is in the memory of r, to a
+// Go value.
+func (r *Record) load(p unsafe.Pointer, t Type) interface{} {
+	if t.agg != nil {
+		return &Record{typ: t, ptr: p, mem: r.mem}
+	}
+
+	switch {
+	case t == Bool:
+		return *(*bool)(p)
+	case sameType(t, Int8):
+		return *(*int8)(p)
+	case sameType(t, Int16):
+		return *(*int16)(p)
+	case sameType(t, Int32):
+		return *(*int32)(p)
+	case sameType(t, Int64):
+		return *(*int64)(p)
+	case sameType(t, UInt8):
+		return *(*uint8)(p)
+	case sameType(t, UInt16):
+		return *(*uint16)(p)
+	case sameType(t, UInt32):
+		return *(*uint32)(p)
+	case sameType(t, UInt64):
+		return *(*uint64)(p)
+	case sameType(t, Float):
+		return *(*float32)(p)
+	case sameType(t, Double):
+		return *(*float64)(p)
+	case sameType(t, ComplexFloat):
+		return *(*complex64)(p)
+	case sameType(t, ComplexDouble):
+		return *(*complex128)(p)
+	case sameType(t, LongDoubleType):
+		var x LongDouble
+		x.UnmarshalFFI(p)
+		return x
+	case sameType(t, Pointer):
+		return *(*unsafe.Pointer)(p)
+	default:
+		panic(fmt.Sprintf("ffi: cannot load value of type %s from %s", t, r.typ))
+	}
+}
+
+// store writes the Go value v to the C value of type t at p.
+func store(p unsafe.Pointer, t Type, v reflect.Value, name string) {
+	if m, ok := marshalerOf(v); ok && sameStoredType(m.FFIType(), t) {
+		m.MarshalFFI(p)
+		return
+	}
+
+	if _, _, ok := intRange(t); ok {
+		var n uint64
+
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = uint64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = v.Uint()
+		case reflect.Bool:
+			if v.Bool() {
+				n = 1
+			}
+		default:
+			cannotStore(t, v, name)
+		}
+
+		switch t.size() {
+		case 1:
+			*(*uint8)(p) = uint8(n)
+		case 2:
+			*(*uint16)(p) = uint16(n)
+		case 4:
+			*(*uint32)(p) = uint32(n)
+		default:
+			*(*uint64)(p) = n
+		}
+		return
+	}
+
+	switch {
+	case sameType(t, Float), sameType(t, Double):
+		var f float64
+
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			f = float64(v.Uint())
+		default:
+			cannotStore(t, v, name)
+		}
+
+		if sameType(t, Float) {
+			*(*float32)(p) = float32(f)
+		} else {
+			*(*float64)(p) = f
+		}
+
+	case sameType(t, ComplexFloat), sameType(t, ComplexDouble):
+		if v.Kind() != reflect.Complex64 && v.Kind() != reflect.Complex128 {
+			cannotStore(t, v, name)
+		}
+
+		if sameType(t, ComplexFloat) {
+			*(*complex64)(p) = complex64(v.Complex())
+		} else {
+			*(*complex128)(p) = v.Complex()
+		}
+
+	case sameType(t, Pointer):
+		switch {
+		case !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()):
+			*(*unsafe.Pointer)(p) = nil
+		case v.Kind() == reflect.UnsafePointer:
+			*(*unsafe.Pointer)(p) = v.UnsafePointer()
+		case v.Kind() == reflect.Uintptr:
+			*(*uintptr)(p) = uintptr(v.Uint())
+		case v.Type() == reflect.TypeOf((*Record)(nil)):
+			*(*unsafe.Pointer)(p) = v.Interface().(*Record).Pointer()
+		default:
+			cannotStore(t, v, name)
+		}
+
+	default:
+		cannotStore(t, v, name)
+	}
+}
+
+// sameStoredType returns true if values of type m can be copied to fields of
+// type t. Struct and array types must be the same type since types of equal
+// sizes may have different layouts.
+func sameStoredType(m Type, t Type) bool {
+	if m.agg != nil || t.agg != nil {
+		return m.abi == t.abi
+	}
+	return sameType(m, t)
+}
+
+func cannotStore(t Type, v reflect.Value, name string) {
+	panic(fmt.Sprintf("ffi: cannot assign %s to %s of type %s", describeValue(v), name, t))
+}
@@ -0,0 +1,104 @@
+package ffi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Strict enables range checks of the values passed to Call and to functions
+// bound with Bind. When set, a Go value which does not fit in the C type of
+// its argument, like a Go int holding a value larger than a 32 bits C int, is
+// reported with a *RangeError instead of being truncated.
+//
+// Arguments of enumeration types must be one of their values. Pointer arguments
+// declared with PointerTo are also checked to refer to Go values of the pointed
+// type, mismatches are reported with a *TypeError.
+//
+// Call returns the error, bound functions return it if their last result is
+// an error and panic with it otherwise.
+var Strict bool
+
+// RangeError is returned in strict mode when an argument cannot be represented
+// by its C type, or is not a value of its enumeration type.
+type RangeError struct {
+	// Index of the argument in the C function signature.
+	Index int
+	// Value of the argument.
+	Value interface{}
+	// Type of the C argument.
+	Type Type
+}
+
+func (e *RangeError) Error() string {
+	v := e.Type.FormatValue(e.Value)
+
+	if e.Type.enum != nil {
+		return fmt.Sprintf("ffi: argument %d (%s) is not a value of %s (%s)", e.Index, v, e.Type, e.Type.enum.formatNames())
+	}
+
+	return fmt.Sprintf("ffi: argument %d (%s) overflows %s", e.Index, v, e.Type)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func checkStrictArgs(args []reflect.Value, types []Type) error {
+	for i, a := range args {
+		if !inRange(a, types[i]) || !isEnumValue(a, types[i]) {
+			return &RangeError{Index: i, Value: a.Interface(), Type: types[i]}
+		}
+
+		if elem, ok := types[i].Elem(); ok && !pointsTo(a, elem) {
+			return &TypeError{Index: i, Value: a.Type(), Type: types[i]}
+		}
+	}
+	return nil
+}
+
+// inRange returns true if v can be converted to the C type t without loss of
+// magnitude, values of types which are not numbers are always in range.
+func inRange(v reflect.Value, t Type) bool {
+	if min, max, ok := intRange(t); ok {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := v.Int()
+			return n >= min && (n < 0 || uint64(n) <= max)
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return v.Uint() <= max
+		}
+	}
+
+	if sameType(t, Float) {
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f := v.Float()
+			return math.IsInf(f, 0) || math.IsNaN(f) || math.Abs(f) <= math.MaxFloat32
+		}
+	}
+
+	return true
+}
+
+func intRange(t Type) (min int64, max uint64, ok bool) {
+	switch {
+	case sameType(t, Int8):
+		return math.MinInt8, math.MaxInt8, true
+	case sameType(t, Int16):
+		return math.MinInt16, math.MaxInt16, true
+	case sameType(t, Int32):
+		return math.MinInt32, math.MaxInt32, true
+	case sameType(t, Int64):
+		return math.MinInt64, math.MaxInt64, true
+	case sameType(t, UInt8):
+		return 0, math.MaxUint8, true
+	case sameType(t, UInt16):
+		return 0, math.MaxUint16, true
+	case sameType(t, UInt32):
+		return 0, math.MaxUint32, true
+	case sameType(t, UInt64):
+		return 0, math.MaxUint64, true
+	default:
+		return 0, 0, false
+	}
+}
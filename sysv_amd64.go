@@ -0,0 +1,431 @@
+//go:build linux && nolibffi
+
+package ffi
+
+// #include <stdint.h>
+//
+// extern void GoSysVClosureCallback(void *);
+//
+// static uintptr_t ffi_sysv_callback__(void) {
+//   return (uintptr_t) GoSysVClosureCallback;
+// }
+//
+import "C"
+import (
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	"github.com/achille-roussel/go-ffi/internal/sysv"
+)
+
+// This file implements the backend selected by the nolibffi build tag, it calls
+// C functions directly following the System V AMD64 calling convention.
+
+const (
+	OK         Status = 0
+	BadTypedef Status = 1
+	BadABI     Status = 2
+)
+
+// Type kinds have the same values as the FFI_TYPE_* constants of libffi.
+const (
+	kindVoid       = 0
+	kindFloat      = 2
+	kindDouble     = 3
+	kindLongDouble = 4
+	kindUInt8      = 5
+	kindSInt8      = 6
+	kindUInt16     = 7
+	kindSInt16     = 8
+	kindUInt32     = 9
+	kindSInt32     = 10
+	kindUInt64     = 11
+	kindSInt64     = 12
+	kindStruct     = 13
+	kindPointer    = 14
+	kindComplex    = 15
+)
+
+type abiType struct {
+	size uintptr
+	kind int
+}
+
+var (
+	abiVoid = &abiType{0, kindVoid}
+
+	abiUChar  = &abiType{1, kindUInt8}
+	abiUShort = &abiType{2, kindUInt16}
+	abiUInt   = &abiType{4, kindUInt32}
+	abiULong  = &abiType{8, kindUInt64}
+
+	abiUInt8  = &abiType{1, kindUInt8}
+	abiUInt16 = &abiType{2, kindUInt16}
+	abiUInt32 = &abiType{4, kindUInt32}
+	abiUInt64 = &abiType{8, kindUInt64}
+
+	abiChar  = &abiType{1, kindSInt8}
+	abiShort = &abiType{2, kindSInt16}
+	abiInt   = &abiType{4, kindSInt32}
+	abiLong  = &abiType{8, kindSInt64}
+
+	abiInt8  = &abiType{1, kindSInt8}
+	abiInt16 = &abiType{2, kindSInt16}
+	abiInt32 = &abiType{4, kindSInt32}
+	abiInt64 = &abiType{8, kindSInt64}
+
+	abiFloat  = &abiType{4, kindFloat}
+	abiDouble = &abiType{8, kindDouble}
+
+	abiPointer = &abiType{8, kindPointer}
+
+	abiBool = &abiType{1, kindUInt8}
+
+	abiSizeT   = &abiType{8, kindUInt64}
+	abiSSizeT  = &abiType{8, kindSInt64}
+	abiPtrDiff = &abiType{8, kindSInt64}
+	abiIntPtr  = &abiType{8, kindSInt64}
+
+	abiComplexFloat  = &abiType{8, kindComplex}
+	abiComplexDouble = &abiType{16, kindComplex}
+	abiLongDouble    = &abiType{16, kindLongDouble}
+)
+
+func (t Type) size() uintptr {
+	return t.abi.size
+}
+
+func (t Type) kind() int {
+	return t.abi.kind
+}
+
+func (t Type) retSize() uintptr {
+	switch t.abi.kind {
+	case kindVoid:
+		return 0
+	case kindFloat:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// align returns the alignment of scalar types, the alignment of structs is
+// recorded by the Type.
+func (t Type) align() uintptr {
+	switch t.abi.kind {
+	case kindVoid:
+		return 1
+	case kindComplex:
+		return t.abi.size / 2
+	default:
+		return t.abi.size
+	}
+}
+
+// newStructABI returns the type of a struct, which the backend can describe
+// but not pass to or return from functions.
+func newStructABI(size uintptr, align uintptr, elems []*abiType) *abiType {
+	return &abiType{size, kindStruct}
+}
+
+const (
+	locInt = iota
+	locSSE
+	locStack
+)
+
+type argLoc struct {
+	class uint8
+	index uint8
+}
+
+type abiInterface struct {
+	locs   []argLoc
+	nsse   int
+	nstack int
+}
+
+// The interfaces of this backend live in Go memory, there is nothing to
+// release.
+func (cif *Interface) releaseOnGC() {}
+
+func (cif *Interface) release() {}
+
+func (cif *Interface) prepare() Status {
+	if !sysvSupported(cif.ret.abi) {
+		return BadTypedef
+	}
+
+	nint := 0
+	cif.locs = make([]argLoc, len(cif.args))
+
+	for i, a := range cif.args {
+		if !sysvSupported(a.abi) || a.abi.kind == kindVoid {
+			return BadTypedef
+		}
+
+		switch {
+		case isSSE(a.abi) && cif.nsse < 8:
+			cif.locs[i] = argLoc{locSSE, uint8(cif.nsse)}
+			cif.nsse++
+
+		case !isSSE(a.abi) && nint < 6:
+			cif.locs[i] = argLoc{locInt, uint8(nint)}
+			nint++
+
+		case cif.nstack < sysv.MaxStack:
+			cif.locs[i] = argLoc{locStack, uint8(cif.nstack)}
+			cif.nstack++
+
+		default:
+			return BadABI
+		}
+	}
+
+	return OK
+}
+
+// sysvSupported returns false for the types which do not fit in a single
+// register: structs, long double (passed on the x87 stack) and double _Complex.
+func sysvSupported(t *abiType) bool {
+	return t != nil && t.kind != kindStruct && t.kind != kindLongDouble && t.size <= 8
+}
+
+// isSSE returns true if values of type t are passed in SSE registers, float
+// _Complex has its two parts packed in the low 64 bits of the register.
+func isSSE(t *abiType) bool {
+	return t.kind == kindFloat || t.kind == kindDouble || t.kind == kindComplex
+}
+
+func (cif *Interface) call(fptr unsafe.Pointer, ret unsafe.Pointer, args []unsafe.Pointer) error {
+	f := sysvFrames.Get().(*sysv.Frame)
+
+	for i, a := range args {
+		cif.setArg(f, i, loadBits(cif.args[i].abi, a))
+	}
+
+	cif.sysvCall(f, fptr)
+
+	if ret != nil {
+		r := cif.retBits(f)
+		copy(unsafe.Slice((*byte)(ret), cif.ret.retSize()), unsafe.Slice((*byte)(unsafe.Pointer(&r)), 8))
+	}
+
+	sysvFrames.Put(f)
+	return nil
+}
+
+func (cif *Interface) callBits(fptr unsafe.Pointer, args []uint64) uint64 {
+	cif.checkBits()
+	f := sysvFrames.Get().(*sysv.Frame)
+
+	for i, a := range args {
+		cif.setArg(f, i, extendBits(cif.args[i].abi, a))
+	}
+
+	cif.sysvCall(f, fptr)
+	ret := cif.retBits(f)
+	sysvFrames.Put(f)
+	return ret
+}
+
+func (cif *Interface) mapCall(fptr unsafe.Pointer, n int, out unsafe.Pointer, outsize uintptr, in []unsafe.Pointer, insize []uintptr) {
+	const chunk = 256
+
+	frames := make([]sysv.Frame, min(n, chunk))
+
+	for base := 0; base < n; base += chunk {
+		batch := frames[:min(n-base, chunk)]
+
+		for i := range batch {
+			for j, a := range cif.args {
+				cif.setArg(&batch[i], j, loadBits(a.abi, unsafe.Add(in[j], uintptr(base+i)*insize[j])))
+			}
+			cif.prepareFrame(&batch[i], fptr)
+		}
+
+		sysv.CallBatch(batch)
+
+		if out != nil {
+			for i := range batch {
+				r := cif.retBits(&batch[i])
+				copy(unsafe.Slice((*byte)(unsafe.Add(out, uintptr(base+i)*outsize)), outsize), unsafe.Slice((*byte)(unsafe.Pointer(&r)), 8))
+			}
+		}
+	}
+}
+
+func (cif *Interface) setArg(f *sysv.Frame, i int, v uint64) {
+	switch loc := cif.locs[i]; loc.class {
+	case locInt:
+		f.Ints[loc.index] = v
+	case locSSE:
+		f.SSE[loc.index] = v
+	default:
+		f.Stack[loc.index] = v
+	}
+}
+
+func (cif *Interface) prepareFrame(f *sysv.Frame, fptr unsafe.Pointer) {
+	f.Fn = uintptr(fptr)
+	f.NSSE = uint64(cif.nsse)
+	f.NStack = uint64(cif.nstack)
+}
+
+func (cif *Interface) sysvCall(f *sysv.Frame, fptr unsafe.Pointer) {
+	cif.prepareFrame(f, fptr)
+	sysv.Call(f)
+}
+
+// retBits returns the value of the return register of a call to a function of
+// type cif, integers are widened to 64 bits like libffi does.
+func (cif *Interface) retBits(f *sysv.Frame) uint64 {
+	switch t := cif.ret.abi; t.kind {
+	case kindVoid:
+		return 0
+	case kindFloat:
+		return f.XMM0 & 0xffffffff
+	case kindDouble, kindComplex:
+		return f.XMM0
+	default:
+		return extendBits(t, f.RAX)
+	}
+}
+
+// loadBits reads a value of type t from p, the C ABI requires integers smaller
+// than 32 bits to be extended by the caller.
+func loadBits(t *abiType, p unsafe.Pointer) uint64 {
+	switch t.kind {
+	case kindUInt8, kindSInt8:
+		return extendBits(t, uint64(*(*uint8)(p)))
+	case kindUInt16, kindSInt16:
+		return extendBits(t, uint64(*(*uint16)(p)))
+	case kindUInt32, kindSInt32, kindFloat:
+		return extendBits(t, uint64(*(*uint32)(p)))
+	default:
+		return *(*uint64)(p)
+	}
+}
+
+func extendBits(t *abiType, v uint64) uint64 {
+	switch t.kind {
+	case kindUInt8:
+		return uint64(uint8(v))
+	case kindSInt8:
+		return uint64(int8(v))
+	case kindUInt16:
+		return uint64(uint16(v))
+	case kindSInt16:
+		return uint64(int16(v))
+	case kindUInt32, kindFloat:
+		return uint64(uint32(v))
+	case kindSInt32:
+		return uint64(int32(v))
+	default:
+		return v
+	}
+}
+
+var sysvFrames = sync.Pool{
+	New: func() interface{} { return new(sysv.Frame) },
+}
+
+type batchState struct {
+	frames []sysv.Frame
+}
+
+func (b *Batch) run() {
+	if cap(b.frames) < len(b.calls) {
+		b.frames = make([]sysv.Frame, len(b.calls))
+	}
+
+	frames := b.frames[:len(b.calls)]
+
+	for i, c := range b.calls {
+		cif := &b.cifs[i]
+
+		for j, a := range cif.args {
+			cif.setArg(&frames[i], j, loadBits(a.abi, unsafe.Pointer(&b.slots[b.offsets[c.args+uintptr(j)]])))
+		}
+
+		cif.prepareFrame(&frames[i], c.fptr)
+	}
+
+	sysv.CallBatch(frames)
+
+	for i, c := range b.calls {
+		b.slots[c.ret] = b.cifs[i].retBits(&frames[i])
+	}
+}
+
+func (f *Frame) alloc() {
+	f.ret = new([2]uint64)
+	f.args = make([]uint64, len(f.cif.args))
+	f.mem = unsafe.Pointer(new(sysv.Frame))
+}
+
+func (f *Frame) call(fptr unsafe.Pointer) {
+	sf := (*sysv.Frame)(f.mem)
+
+	for i, a := range f.args {
+		f.cif.setArg(sf, i, extendBits(f.cif.args[i].abi, a))
+	}
+
+	f.cif.sysvCall(sf, fptr)
+	f.ret[0] = f.cif.retBits(sf)
+}
+
+func destroyFrame(f *Frame) {
+}
+
+func init() {
+	sysv.SetCallback(uintptr(C.ffi_sysv_callback__()))
+}
+
+func constructClosure(fn *function) error {
+	c, err := sysv.NewClosure(uintptr(fn.handle))
+
+	if err != nil {
+		return err
+	}
+
+	fn.fptr = c.Pointer()
+	fn.mptr = unsafe.Pointer(c)
+	return nil
+}
+
+func destroyClosure(fn *function) {
+	(*sysv.Closure)(fn.mptr).Free()
+}
+
+//export GoSysVClosureCallback
+func GoSysVClosureCallback(p unsafe.Pointer) {
+	regs := (*sysv.Regs)(p)
+	fn := cgo.Handle(regs.Ctx).Value().(*callback)
+
+	av := &regs.Args
+
+	for i, loc := range fn.locs {
+		switch loc.class {
+		case locInt:
+			av[i] = unsafe.Pointer(&regs.Ints[loc.index])
+		case locSSE:
+			av[i] = unsafe.Pointer(&regs.SSE[loc.index])
+		default:
+			av[i] = unsafe.Add(regs.Stack, 8*uintptr(loc.index))
+		}
+	}
+
+	regs.RAX = 0
+	regs.XMM0 = 0
+
+	switch t := fn.ret.abi; t.kind {
+	case kindFloat, kindDouble, kindComplex:
+		fn.invoke(unsafe.Pointer(&regs.XMM0), &av[0])
+	default:
+		fn.invoke(unsafe.Pointer(&regs.RAX), &av[0])
+		regs.RAX = extendBits(t, regs.RAX)
+	}
+}
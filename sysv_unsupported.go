@@ -0,0 +1,7 @@
+//go:build nolibffi && !(linux && amd64)
+
+package ffi
+
+// The nolibffi build tag selects the System V backend which is only available
+// on linux/amd64, this reference makes builds on other platforms fail loudly.
+var _ = nolibffi_is_only_supported_on_linux_amd64
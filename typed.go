@@ -0,0 +1,88 @@
+package ffi
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// Scalar is the set of Go types that the typed call functions pass to and
+// receive from C without going through reflection or the heap. Pointers have
+// to be passed as unsafe.Pointer.
+//
+// Values are copied bit for bit into the argument slots, the Interface given
+// to the call decides how C interprets them (a Go int passed where the
+// interface declares Int is truncated to 32 bits, just like Call does).
+type Scalar interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~bool | ~complex64 |
+		unsafe.Pointer
+}
+
+func Call0[R Scalar](cif *Interface, fptr unsafe.Pointer) R {
+	return fromBits[R](cif.callBits(fptr, nil))
+}
+
+func Call1[R, A1 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1)})
+	runtime.KeepAlive(a1)
+	return fromBits[R](ret)
+}
+
+func Call2[R, A1, A2 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1, a2 A2) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1), toBits(a2)})
+	runtime.KeepAlive(a1)
+	runtime.KeepAlive(a2)
+	return fromBits[R](ret)
+}
+
+func Call3[R, A1, A2, A3 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1, a2 A2, a3 A3) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1), toBits(a2), toBits(a3)})
+	runtime.KeepAlive(a1)
+	runtime.KeepAlive(a2)
+	runtime.KeepAlive(a3)
+	return fromBits[R](ret)
+}
+
+func Call4[R, A1, A2, A3, A4 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1, a2 A2, a3 A3, a4 A4) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1), toBits(a2), toBits(a3), toBits(a4)})
+	runtime.KeepAlive(a1)
+	runtime.KeepAlive(a2)
+	runtime.KeepAlive(a3)
+	runtime.KeepAlive(a4)
+	return fromBits[R](ret)
+}
+
+func Call5[R, A1, A2, A3, A4, A5 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1, a2 A2, a3 A3, a4 A4, a5 A5) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1), toBits(a2), toBits(a3), toBits(a4), toBits(a5)})
+	runtime.KeepAlive(a1)
+	runtime.KeepAlive(a2)
+	runtime.KeepAlive(a3)
+	runtime.KeepAlive(a4)
+	runtime.KeepAlive(a5)
+	return fromBits[R](ret)
+}
+
+func Call6[R, A1, A2, A3, A4, A5, A6 Scalar](cif *Interface, fptr unsafe.Pointer, a1 A1, a2 A2, a3 A3, a4 A4, a5 A5, a6 A6) R {
+	ret := cif.callBits(fptr, []uint64{toBits(a1), toBits(a2), toBits(a3), toBits(a4), toBits(a5), toBits(a6)})
+	runtime.KeepAlive(a1)
+	runtime.KeepAlive(a2)
+	runtime.KeepAlive(a3)
+	runtime.KeepAlive(a4)
+	runtime.KeepAlive(a5)
+	runtime.KeepAlive(a6)
+	return fromBits[R](ret)
+}
+
+// The argument and return slots are 64 bits wide and values are stored in
+// their low-order bytes, which is where the backends read and write them on
+// the little-endian platforms the package supports.
+
+func toBits[T Scalar](v T) (b uint64) {
+	*(*T)(unsafe.Pointer(&b)) = v
+	return
+}
+
+func fromBits[T Scalar](b uint64) T {
+	return *(*T)(unsafe.Pointer(&b))
+}